@@ -0,0 +1,91 @@
+// protoc-gen-extproc generates typed ext_proc hooks for gRPC services.
+//
+// For each service it writes an interface with a hook for the request and
+// the response messages of every method, a type that passes every message,
+// and a function registering an implementation with package extproc:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --extproc_out=. --extproc_opt=paths=source_relative \
+//	  echo/echo.proto
+//
+// writes echo/echo_extproc.pb.go next to echo/echo.pb.go.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const extprocPackage = protogen.GoImportPath("github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc")
+
+func main() {
+	var flags flag.FlagSet
+	protogen.Options{ParamFunc: flags.Set}.Run(generate)
+}
+
+func generate(gen *protogen.Plugin) error {
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	for _, f := range gen.Files {
+		if f.Generate && len(f.Services) > 0 {
+			generateFile(gen, f)
+		}
+	}
+	return nil
+}
+
+func generateFile(gen *protogen.Plugin, file *protogen.File) {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_extproc.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-extproc. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	for _, s := range file.Services {
+		generateService(g, s)
+	}
+}
+
+func generateService(g *protogen.GeneratedFile, s *protogen.Service) {
+	hooks := s.GoName + "Hooks"
+	unimplemented := "Unimplemented" + hooks
+	verdict := g.QualifiedGoIdent(extprocPackage.Ident("Verdict"))
+	context := g.QualifiedGoIdent(extprocPackage.Ident("Context"))
+
+	g.P()
+	g.P("// ", hooks, " is called with each message of the calls to ", s.Desc.FullName(), ",")
+	g.P("// once the filter has decoded it.")
+	g.P("type ", hooks, " interface {")
+	for _, m := range s.Methods {
+		g.P("On", m.GoName, "Request(*", context, ", *", g.QualifiedGoIdent(m.Input.GoIdent), ") ", verdict)
+		g.P("On", m.GoName, "Response(*", context, ", *", g.QualifiedGoIdent(m.Output.GoIdent), ") ", verdict)
+	}
+	g.P("}")
+
+	g.P()
+	g.P("// ", unimplemented, " passes every message.  Embed it to implement only")
+	g.P("// some of the hooks.")
+	g.P("type ", unimplemented, " struct{}")
+	for _, m := range s.Methods {
+		g.P()
+		g.P("func (", unimplemented, ") On", m.GoName, "Request(*", context, ", *", g.QualifiedGoIdent(m.Input.GoIdent), ") ", verdict, " {")
+		g.P("return ", extprocPackage.Ident("Pass"), "()")
+		g.P("}")
+		g.P()
+		g.P("func (", unimplemented, ") On", m.GoName, "Response(*", context, ", *", g.QualifiedGoIdent(m.Output.GoIdent), ") ", verdict, " {")
+		g.P("return ", extprocPackage.Ident("Pass"), "()")
+		g.P("}")
+	}
+
+	g.P()
+	g.P("// Register", hooks, " registers h for every method of ", s.Desc.FullName(), ".")
+	g.P("// Every method is decoded, including those h passes.")
+	g.P("func Register", hooks, "(h ", hooks, ") {")
+	for _, m := range s.Methods {
+		path := fmt.Sprintf("%q", "/"+string(s.Desc.FullName())+"/"+string(m.Desc.Name()))
+		g.P(extprocPackage.Ident("RegisterRequestHandler"), "(", path, ", h.On", m.GoName, "Request)")
+		g.P(extprocPackage.Ident("RegisterResponseHandler"), "(", path, ", h.On", m.GoName, "Response)")
+	}
+	g.P("}")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden file")
+
+// golden is the generated file checked in next to echo.pb.go.
+const golden = "../../../grpc_server/echo/echo_extproc.pb.go"
+
+func TestGolden(t *testing.T) {
+	b, err := os.ReadFile("../../../grpc_server/echo/echo.proto.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"echo/echo.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "echo/echo_extproc.pb.go" {
+		t.Fatalf("generated %v, want echo/echo_extproc.pb.go", resp.File)
+	}
+	got := []byte(resp.File[0].GetContent())
+
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s; run go test -update to rewrite it:\n%s", golden, got)
+	}
+
+	// the checked in file must build against package extproc
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	out, err := exec.Command("go", "vet", "github.com/salrashid123/envoy_grpc_decode/echo").CombinedOutput()
+	if err != nil {
+		t.Errorf("go vet echo: %v\n%s", err, out)
+	}
+}
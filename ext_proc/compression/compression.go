@@ -0,0 +1,177 @@
+// Package compression decompresses and recompresses gRPC message payloads
+// using the codec named in the grpc-encoding header.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Identity is the grpc-encoding value for uncompressed messages.
+const Identity = "identity"
+
+var (
+	// ErrUnsupported is returned for a grpc-encoding with no registered Compressor.
+	ErrUnsupported = errors.New("compression: unsupported grpc-encoding")
+	// ErrTooLarge is returned when a message decompresses to more than the allowed size.
+	ErrTooLarge = errors.New("compression: decompressed message exceeds maximum size")
+)
+
+// Compressor wraps streams with a single compression codec.
+type Compressor interface {
+	// Name is the value used for this codec in grpc-encoding.
+	Name() string
+	Compress(w io.Writer) (io.WriteCloser, error)
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Compressor{}
+)
+
+func init() {
+	Register(gzipCompressor{})
+	Register(deflateCompressor{})
+	Register(zstdCompressor{})
+	Register(snappyCompressor{})
+}
+
+// Register makes c available under c.Name(), replacing any previous codec with that name.
+func Register(c Compressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[strings.ToLower(c.Name())] = c
+}
+
+// Get returns the Compressor registered for name.
+func Get(name string) (Compressor, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, name)
+	}
+	return c, nil
+}
+
+// ParseAcceptEncoding splits a grpc-accept-encoding header value into codec names.
+func ParseAcceptEncoding(v string) []string {
+	var names []string
+	for _, n := range strings.Split(v, ",") {
+		n = strings.TrimSpace(n)
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Compress returns payload compressed with the codec registered for name.
+func Compress(name string, payload []byte) ([]byte, error) {
+	c, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	w, err := c.Compress(&out)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decompress returns payload decompressed with the codec registered for name.
+// It fails with ErrTooLarge rather than inflate more than maxSize bytes.
+func Decompress(name string, payload []byte, maxSize int) ([]byte, error) {
+	c, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Decompress(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	b, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxSize)
+	}
+	return b, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateCompressor uses the zlib wrapped format, which is what the HTTP
+// "deflate" content-coding (and therefore grpc-encoding: deflate) means.
+type deflateCompressor struct{}
+
+func (deflateCompressor) Name() string { return "deflate" }
+
+func (deflateCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+func (deflateCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string { return "zstd" }
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// snappyCompressor uses the snappy framing format.
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string { return "snappy" }
+
+func (snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
@@ -0,0 +1,210 @@
+// Package config loads the filter's descriptor sets and rules into
+// immutable snapshots and swaps in new versions when the files change.
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/metrics"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/redact"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/rules"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
+)
+
+// Sources names the files a Snapshot is built from.
+type Sources struct {
+	DescriptorSets []string
+	Rules          []string
+
+	// SensitiveOption names the bool field option marking fields to redact,
+	// eg privacy.sensitive; empty turns it off.
+	SensitiveOption   protoreflect.FullName
+	SensitiveStrategy redact.Strategy
+}
+
+// Snapshot is one validated version of the configuration.  It is never
+// modified once loaded, so a stream can keep using the snapshot it started
+// with while newer ones are swapped in.
+type Snapshot struct {
+	// Version is a digest of the contents of every source file.
+	Version  string
+	Registry *schema.Registry
+	Rules    *rules.Set
+	// Sensitive is nil unless Sources.SensitiveOption is set.
+	Sensitive *redact.Sensitive
+}
+
+// Load reads and validates every file in src.
+func Load(src Sources) (*Snapshot, error) {
+	f, err := read(src)
+	if err != nil {
+		return nil, err
+	}
+	return f.build()
+}
+
+// files is the raw contents of a Sources.
+type files struct {
+	version        string
+	descriptorSets map[string][]byte
+	rules          map[string][]byte
+	src            Sources
+}
+
+func read(src Sources) (*files, error) {
+	h := sha256.New()
+	f := &files{src: src, descriptorSets: map[string][]byte{}, rules: map[string][]byte{}}
+	for _, group := range []struct {
+		paths []string
+		into  map[string][]byte
+	}{
+		{src.DescriptorSets, f.descriptorSets},
+		{src.Rules, f.rules},
+	} {
+		for _, p := range group.paths {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("config: reading %s: %w", p, err)
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00", p, len(b))
+			h.Write(b)
+			group.into[p] = b
+		}
+	}
+	f.version = hex.EncodeToString(h.Sum(nil))[:12]
+	return f, nil
+}
+
+func (f *files) build() (*Snapshot, error) {
+	var sets []*descriptorpb.FileDescriptorSet
+	for _, p := range f.src.DescriptorSets {
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(f.descriptorSets[p], set); err != nil {
+			return nil, fmt.Errorf("config: parsing %s: %w", p, err)
+		}
+		// an empty file parses as an empty set; most likely it is being
+		// rewritten, so don't drop every schema because of it
+		if len(set.File) == 0 {
+			return nil, fmt.Errorf("config: %s has no files", p)
+		}
+		sets = append(sets, set)
+	}
+	registry, err := schema.NewRegistry(sets...)
+	if err != nil {
+		return nil, err
+	}
+
+	var sensitive *redact.Sensitive
+	if f.src.SensitiveOption != "" {
+		sensitive = redact.NewSensitive(f.src.SensitiveOption, f.src.SensitiveStrategy)
+		n, err := sensitive.AddFiles(registry.Files())
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("found %d fields marked (%s)", n, f.src.SensitiveOption)
+	}
+
+	all := &rules.File{}
+	for _, p := range f.src.Rules {
+		rf, err := rules.Parse(f.rules[p])
+		if err != nil {
+			return nil, fmt.Errorf("config: parsing %s: %w", p, err)
+		}
+		all.Rules = append(all.Rules, rf.Rules...)
+		all.Decode = append(all.Decode, rf.Decode...)
+		all.Processing = append(all.Processing, rf.Processing...)
+	}
+	ruleSet, err := rules.NewSet(all, registry, sensitive)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Version:   f.version,
+		Registry:  registry,
+		Rules:     ruleSet,
+		Sensitive: sensitive,
+	}, nil
+}
+
+// Watcher holds the current Snapshot and periodically reloads it.
+type Watcher struct {
+	src     Sources
+	current atomic.Pointer[Snapshot]
+}
+
+// NewWatcher loads the initial snapshot; unlike later reloads, a failure
+// here is returned to the caller.
+func NewWatcher(src Sources) (*Watcher, error) {
+	s, err := Load(src)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{src: src}
+	w.swap(s)
+	return w, nil
+}
+
+// Current returns the snapshot new streams should use.
+func (w *Watcher) Current() *Snapshot {
+	return w.current.Load()
+}
+
+// Run checks the source files every interval until ctx is done.  A new
+// version that fails to load is logged and counted once, and the last good
+// snapshot stays in use.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	// failed is the version or read error last reported, so a broken file
+	// is only logged and counted once.
+	var failed string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		f, err := read(w.src)
+		if err != nil {
+			if err.Error() != failed {
+				failed = err.Error()
+				log.Printf("config reload failed, keeping version %s: %v", w.Current().Version, err)
+				metrics.ConfigReloads.WithLabelValues("failure").Inc()
+			}
+			continue
+		}
+		if f.version == w.Current().Version || f.version == failed {
+			continue
+		}
+		s, err := f.build()
+		if err != nil {
+			failed = f.version
+			log.Printf("config version %s is invalid, keeping version %s: %v", f.version, w.Current().Version, err)
+			metrics.ConfigReloads.WithLabelValues("failure").Inc()
+			continue
+		}
+		w.swap(s)
+		metrics.ConfigReloads.WithLabelValues("success").Inc()
+	}
+}
+
+func (w *Watcher) swap(s *Snapshot) {
+	old := w.current.Swap(s)
+	if old != nil {
+		metrics.ConfigInfo.DeleteLabelValues(old.Version)
+	}
+	metrics.ConfigInfo.WithLabelValues(s.Version).Set(1)
+	log.Printf("loaded config version %s", s.Version)
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/metrics"
+)
+
+const (
+	unaryRules = `
+rules:
+- method: /echo.EchoServer/SayHelloUnary
+  actions:
+  - set: name
+    value: bob
+`
+	streamRules = `
+rules:
+- method: /echo.EchoServer/SayHelloServerStream
+  actions:
+  - set: name
+    value: bob
+`
+	// badRules names a field EchoRequest does not have.
+	badRules = `
+rules:
+- method: /echo.EchoServer/SayHelloUnary
+  actions:
+  - set: nickname
+    value: bob
+`
+)
+
+func write(t *testing.T, p, s string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func failures(t *testing.T) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := metrics.ConfigReloads.WithLabelValues("failure").Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	desc, err := os.ReadFile("../../grpc_server/echo/echo.proto.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	descPath := filepath.Join(dir, "echo.proto.pb")
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(descPath, desc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	write(t, rulesPath, unaryRules)
+
+	w, err := NewWatcher(Sources{DescriptorSets: []string{descPath}, Rules: []string{rulesPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := w.Current()
+	if !first.Rules.Covers("/echo.EchoServer/SayHelloUnary") {
+		t.Fatal("initial rules do not cover SayHelloUnary")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// a valid change is swapped in as a whole
+	write(t, rulesPath, streamRules)
+	eventually(t, "the new rules", func() bool { return w.Current() != first })
+	second := w.Current()
+	if second.Version == first.Version {
+		t.Errorf("version unchanged after reload: %s", second.Version)
+	}
+	if second.Rules.Covers("/echo.EchoServer/SayHelloUnary") || !second.Rules.Covers("/echo.EchoServer/SayHelloServerStream") {
+		t.Error("reloaded rules are not the new ones")
+	}
+	if second.Registry == first.Registry {
+		t.Error("descriptors were not reloaded with the rules")
+	}
+	if !first.Rules.Covers("/echo.EchoServer/SayHelloUnary") {
+		t.Error("reloading changed the old snapshot")
+	}
+
+	// a broken change is counted once and the last good snapshot kept
+	before := failures(t)
+	write(t, rulesPath, badRules)
+	eventually(t, "the failure to be counted", func() bool { return failures(t) > before })
+	time.Sleep(50 * time.Millisecond)
+	if n := failures(t) - before; n != 1 {
+		t.Errorf("failure counted %v times, want once", n)
+	}
+	if w.Current() != second {
+		t.Error("a broken config replaced the last good one")
+	}
+
+	// so is a descriptor set being rewritten
+	before = failures(t)
+	write(t, descPath, "")
+	eventually(t, "the failure to be counted", func() bool { return failures(t) > before })
+	if w.Current() != second {
+		t.Error("an empty descriptor set replaced the last good one")
+	}
+
+	// and fixing the files swaps again
+	if err := os.WriteFile(descPath, desc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	write(t, rulesPath, unaryRules)
+	eventually(t, "the fixed config", func() bool { return w.Current() != second })
+	if w.Current().Version != first.Version {
+		t.Errorf("version = %s, want %s as the files are the same as at first", w.Current().Version, first.Version)
+	}
+}
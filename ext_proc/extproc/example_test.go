@@ -0,0 +1,30 @@
+package extproc_test
+
+import (
+	"log"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salrashid123/envoy_grpc_decode/echo"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc"
+)
+
+func ExampleRegisterRequestHandler() {
+	extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloUnary",
+		func(c *extproc.Context, req *echo.EchoRequest) extproc.Verdict {
+			if req.Name == "mallory" {
+				return extproc.Deny(status.New(codes.PermissionDenied, "mallory is not allowed"))
+			}
+			c.State.Put("name", req.Name)
+			req.Name = strings.ToLower(req.Name)
+			return extproc.Mutate()
+		})
+	extproc.RegisterResponseHandler("/echo.EchoServer/SayHelloUnary",
+		func(c *extproc.Context, reply *echo.EchoReply) extproc.Verdict {
+			name, _ := c.State.Get("name")
+			log.Printf("replied %q to %v", reply.Message, name)
+			return extproc.Pass()
+		})
+}
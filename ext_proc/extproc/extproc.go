@@ -0,0 +1,202 @@
+// Package extproc lets Go code inspect and rewrite the messages of gRPC
+// calls passing through the filter, with the generated types of those
+// messages rather than descriptors.
+//
+// A handler is registered for one method and one direction, usually from an
+// init function:
+//
+//	func init() {
+//		extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloUnary",
+//			func(c *extproc.Context, req *echo.EchoRequest) extproc.Verdict {
+//				if req.Name == "mallory" {
+//					return extproc.Deny(status.New(codes.PermissionDenied, "mallory is not allowed"))
+//				}
+//				req.Name = strings.ToLower(req.Name)
+//				return extproc.Mutate()
+//			})
+//	}
+//
+// The filter takes care of framing, compression and building the responses
+// to envoy.  A handler sees each message after the rules have been applied
+// to it and before sensitive fields are masked.
+package extproc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/rules"
+)
+
+// Context is what a handler knows about the call besides the message.
+type Context struct {
+	context.Context
+	// Method is the gRPC :path of the call.
+	Method string
+	// Headers are the request headers, keyed by lower-case name, as they
+	// will be forwarded: with the changes of the rules that have run so far,
+	// including those on the current message.
+	Headers map[string]string
+	// Attributes are the attributes envoy has sent, by namespace.
+	Attributes map[string]*structpb.Struct
+	// Metadata is the dynamic metadata envoy has forwarded, by namespace.
+	Metadata map[string]*structpb.Struct
+	// Values are what store rules have saved so far in the call, by key.
+	Values map[string]*structpb.Value
+	// State lasts for the whole call and is shared by the handlers of both
+	// directions.
+	State *State
+}
+
+// State holds values for the length of one call.  The zero value is ready
+// to use.
+type State struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// Get returns the value stored under key, if any.
+func (s *State) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Put stores v under key, replacing any value already there.
+func (s *State) Put(key string, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[string]any{}
+	}
+	s.values[key] = v
+}
+
+// Verdict is what a handler decided to do with a message.
+type Verdict struct {
+	mutate bool
+	deny   *status.Status
+}
+
+// Pass forwards the message as it arrived, ignoring any changes the handler
+// made to it.
+func Pass() Verdict { return Verdict{} }
+
+// Mutate forwards the message with the changes the handler made to it.
+func Mutate() Verdict { return Verdict{mutate: true} }
+
+// Deny ends the call with s instead of forwarding the message.  s must not
+// be OK.
+func Deny(s *status.Status) Verdict { return Verdict{deny: s} }
+
+// Mutated reports whether the message is forwarded with the handler's
+// changes.
+func (v Verdict) Mutated() bool { return v.mutate && v.deny == nil }
+
+// Denied returns the status the call is ended with, or nil.
+func (v Verdict) Denied() *status.Status { return v.deny }
+
+// handler runs a typed handler on a decoded message.
+type handler func(c *Context, m protoreflect.Message) (Verdict, error)
+
+type key struct {
+	method string
+	dir    rules.Direction
+}
+
+var (
+	mu       sync.RWMutex
+	handlers = map[key]handler{}
+)
+
+// RegisterRequestHandler registers fn for the request messages of method, a
+// gRPC :path such as /echo.EchoServer/SayHelloUnary.  T is the generated
+// type of the method's input.  It panics if method already has a request
+// handler.
+func RegisterRequestHandler[T proto.Message](method string, fn func(*Context, T) Verdict) {
+	register(method, rules.Request, fn)
+}
+
+// RegisterResponseHandler registers fn for the response messages of method.
+// T is the generated type of the method's output.  It panics if method
+// already has a response handler.
+func RegisterResponseHandler[T proto.Message](method string, fn func(*Context, T) Verdict) {
+	register(method, rules.Response, fn)
+}
+
+func register[T proto.Message](method string, dir rules.Direction, fn func(*Context, T) Verdict) {
+	if fn == nil {
+		panic("extproc: nil handler for " + method)
+	}
+	var zero T
+	want := zero.ProtoReflect().Descriptor().FullName()
+	h := func(c *Context, m protoreflect.Message) (Verdict, error) {
+		if got := m.Descriptor().FullName(); got != want {
+			return Verdict{}, fmt.Errorf("handler takes %s, message is %s", want, got)
+		}
+		b, err := proto.Marshal(m.Interface())
+		if err != nil {
+			return Verdict{}, err
+		}
+		msg := zero.ProtoReflect().New().Interface().(T)
+		if err := proto.Unmarshal(b, msg); err != nil {
+			return Verdict{}, err
+		}
+		v := fn(c, msg)
+		if !v.Mutated() {
+			return v, nil
+		}
+		// copy the changes back into the message the filter forwards
+		b, err = proto.Marshal(msg)
+		if err != nil {
+			return Verdict{}, err
+		}
+		proto.Reset(m.Interface())
+		if err := proto.Unmarshal(b, m.Interface()); err != nil {
+			return Verdict{}, err
+		}
+		return v, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	k := key{method, dir}
+	if _, ok := handlers[k]; ok {
+		panic(fmt.Sprintf("extproc: %s handler for %s registered twice", dir, method))
+	}
+	handlers[k] = h
+}
+
+// Handled reports whether a handler is registered for either direction of
+// method.
+func Handled(method string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, req := handlers[key{method, rules.Request}]
+	_, resp := handlers[key{method, rules.Response}]
+	return req || resp
+}
+
+// Handle runs the handler registered for c.Method and dir on m, changing m
+// in place if the handler mutates it.  It passes m if there is no handler.
+func Handle(c *Context, dir rules.Direction, m protoreflect.Message) (Verdict, error) {
+	mu.RLock()
+	h, ok := handlers[key{c.Method, dir}]
+	mu.RUnlock()
+	if !ok {
+		return Pass(), nil
+	}
+	v, err := h(c, m)
+	if err == nil && v.deny != nil && v.deny.Code() == codes.OK {
+		return Verdict{}, fmt.Errorf("handler denied %s with OK", c.Method)
+	}
+	return v, err
+}
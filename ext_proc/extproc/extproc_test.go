@@ -0,0 +1,154 @@
+package extproc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/echo"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/rules"
+)
+
+// decoded returns m as the filter holds it: a dynamicpb message.
+func decoded(t *testing.T, m proto.Message) protoreflect.Message {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm := dynamicpb.NewMessage(m.ProtoReflect().Descriptor())
+	if err := proto.Unmarshal(b, dm); err != nil {
+		t.Fatal(err)
+	}
+	return dm
+}
+
+func name(m protoreflect.Message) string {
+	return m.Get(m.Descriptor().Fields().ByName("name")).String()
+}
+
+func TestHandle(t *testing.T) {
+	extproc.RegisterRequestHandler("/test.Handle/Echo", func(c *extproc.Context, req *echo.EchoRequest) extproc.Verdict {
+		switch req.Name {
+		case "pass":
+			req.Name = "ignored"
+			return extproc.Pass()
+		case "mallory":
+			return extproc.Deny(status.New(codes.PermissionDenied, "no"))
+		case "ok":
+			return extproc.Deny(status.New(codes.OK, ""))
+		}
+		req.Name = "hello " + req.Name
+		return extproc.Mutate()
+	})
+
+	tests := []struct {
+		in, want string
+		mutated  bool
+		denied   codes.Code
+		err      bool
+	}{
+		{"pass", "pass", false, codes.OK, false},
+		{"alice", "hello alice", true, codes.OK, false},
+		{"mallory", "mallory", false, codes.PermissionDenied, false},
+		// a denial must end the call with an error
+		{"ok", "ok", false, codes.OK, true},
+	}
+	for _, tc := range tests {
+		m := decoded(t, &echo.EchoRequest{Name: tc.in})
+		v, err := extproc.Handle(&extproc.Context{Context: context.Background(), Method: "/test.Handle/Echo"}, rules.Request, m)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: Handle error = %v, want error %t", tc.in, err, tc.err)
+		}
+		if got := name(m); got != tc.want {
+			t.Errorf("%s: name = %q, want %q", tc.in, got, tc.want)
+		}
+		if v.Mutated() != tc.mutated {
+			t.Errorf("%s: Mutated = %t, want %t", tc.in, v.Mutated(), tc.mutated)
+		}
+		var denied codes.Code
+		if v.Denied() != nil {
+			denied = v.Denied().Code()
+		}
+		if denied != tc.denied {
+			t.Errorf("%s: denied with %v, want %v", tc.in, denied, tc.denied)
+		}
+	}
+}
+
+func TestHandleTypeMismatch(t *testing.T) {
+	called := false
+	extproc.RegisterResponseHandler("/test.Mismatch/Echo", func(c *extproc.Context, reply *echo.EchoReply) extproc.Verdict {
+		called = true
+		return extproc.Mutate()
+	})
+	m := decoded(t, &echo.EchoRequest{Name: "alice"})
+	if _, err := extproc.Handle(&extproc.Context{Method: "/test.Mismatch/Echo"}, rules.Response, m); err == nil {
+		t.Error("Handle with an EchoRequest succeeded, want an error")
+	}
+	if called {
+		t.Error("handler called with the wrong message type")
+	}
+}
+
+func TestHandleUnregistered(t *testing.T) {
+	m := decoded(t, &echo.EchoRequest{Name: "alice"})
+	v, err := extproc.Handle(&extproc.Context{Method: "/test.None/Echo"}, rules.Request, m)
+	if err != nil || v.Mutated() || v.Denied() != nil {
+		t.Errorf("Handle = %v, %v, want Pass", v, err)
+	}
+	if extproc.Handled("/test.None/Echo") {
+		t.Error("Handled = true for a method with no handler")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	fn := func(*extproc.Context, *echo.EchoRequest) extproc.Verdict { return extproc.Pass() }
+	extproc.RegisterRequestHandler("/test.Twice/Echo", fn)
+	if !extproc.Handled("/test.Twice/Echo") {
+		t.Error("Handled = false after registering")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering twice did not panic")
+		}
+	}()
+	extproc.RegisterRequestHandler("/test.Twice/Echo", fn)
+}
+
+// hooks lower-cases the names sent to SayHelloUnary.
+type hooks struct {
+	echo.UnimplementedEchoServerHooks
+}
+
+func (hooks) OnSayHelloUnaryRequest(c *extproc.Context, req *echo.EchoRequest) extproc.Verdict {
+	req.Name = strings.ToLower(req.Name)
+	return extproc.Mutate()
+}
+
+func TestGeneratedHooks(t *testing.T) {
+	echo.RegisterEchoServerHooks(hooks{})
+	for _, method := range []string{"/echo.EchoServer/SayHelloUnary", "/echo.EchoServer/SayHelloBiDiStream"} {
+		if !extproc.Handled(method) {
+			t.Errorf("Handled(%s) = false", method)
+		}
+	}
+
+	m := decoded(t, &echo.EchoRequest{Name: "Alice"})
+	v, err := extproc.Handle(&extproc.Context{Method: "/echo.EchoServer/SayHelloUnary"}, rules.Request, m)
+	if err != nil || !v.Mutated() || name(m) != "alice" {
+		t.Errorf("SayHelloUnary request: Handle = %v, %v, name %q, want alice", v, err, name(m))
+	}
+	m = decoded(t, &echo.EchoRequest{Name: "Alice"})
+	v, err = extproc.Handle(&extproc.Context{Method: "/echo.EchoServer/SayHelloBiDiStream"}, rules.Request, m)
+	if err != nil || v.Mutated() || name(m) != "Alice" {
+		t.Errorf("SayHelloBiDiStream request: Handle = %v, %v, name %q, want it passed", v, err, name(m))
+	}
+}
@@ -0,0 +1,442 @@
+// Package fieldpath addresses fields of a protobuf message by name and reads
+// or writes them through protoreflect, so it works on any message, generated
+// or dynamic.
+//
+// A path is a dot separated list of field names.  A repeated field may be
+// followed by an index or [*], and a map field by a key or [*]:
+//
+//	payment.card.number
+//	order.items[*].sku
+//	order.items[0].sku
+//	labels["env"]
+//	counts[42]
+//
+// Every field but the last must lead to a message.
+//
+// Reading a field inside a singular message that is not set gives the field's
+// default, as it would with generated code.  A member of a oneof that is not
+// the one set selects nothing, as do a missing map key and an index past the
+// end of a list.  [*] on an empty list or map selects nothing.
+//
+// Writing creates any singular message along the path that is not set, and
+// the entry for a map key that is missing.  Writing through or to a oneof
+// member makes it the member that is set, clearing whichever one was.  An
+// index past the end of a list is never created.
+package fieldpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Path is a parsed field path.
+type Path struct {
+	raw   string
+	steps []step
+}
+
+type step struct {
+	name protoreflect.Name
+	sel  *selector
+}
+
+// selector is the [...] following a repeated or map field.
+type selector struct {
+	wildcard bool
+	// lit is the index or key as written; quoted keys are unquoted.
+	lit    string
+	quoted bool
+}
+
+// Parse parses a field path.  It only checks the syntax; use Lookup to check
+// the path against a message type.
+func Parse(s string) (*Path, error) {
+	if s == "" {
+		return nil, fmt.Errorf("fieldpath: empty path")
+	}
+	p := &Path{raw: s}
+	rest := s
+	for {
+		i := strings.IndexAny(rest, ".[")
+		if i < 0 {
+			i = len(rest)
+		}
+		st := step{name: protoreflect.Name(rest[:i])}
+		if !st.name.IsValid() {
+			return nil, fmt.Errorf("fieldpath: %q: invalid field name %q", s, rest[:i])
+		}
+		rest = rest[i:]
+		if strings.HasPrefix(rest, "[") {
+			sel, n, err := parseSelector(rest)
+			if err != nil {
+				return nil, fmt.Errorf("fieldpath: %q: %w", s, err)
+			}
+			st.sel = sel
+			rest = rest[n:]
+		}
+		p.steps = append(p.steps, st)
+		if rest == "" {
+			return p, nil
+		}
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("fieldpath: %q: unexpected %q", s, rest)
+		}
+		rest = rest[1:]
+	}
+}
+
+// parseSelector parses the selector at the start of s and returns its length.
+func parseSelector(s string) (*selector, int, error) {
+	if strings.HasPrefix(s, `["`) {
+		// find the closing quote, skipping escaped ones
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return nil, 0, fmt.Errorf("missing ] after %s", s[:i+1])
+				}
+				key, err := strconv.Unquote(s[1 : i+1])
+				if err != nil {
+					return nil, 0, fmt.Errorf("bad key %s: %w", s[1:i+1], err)
+				}
+				return &selector{lit: key, quoted: true}, i + 2, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("unterminated key in %s", s)
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return nil, 0, fmt.Errorf("missing ] in %s", s)
+	}
+	lit := s[1:end]
+	if lit == "*" {
+		return &selector{wildcard: true}, end + 1, nil
+	}
+	if lit == "" {
+		return nil, 0, fmt.Errorf("empty []")
+	}
+	return &selector{lit: lit}, end + 1, nil
+}
+
+// String returns the path as it was written.
+func (p *Path) String() string {
+	return p.raw
+}
+
+// Wildcard reports whether the path contains [*], and so may select any
+// number of values.
+func (p *Path) Wildcard() bool {
+	for _, st := range p.steps {
+		if st.sel != nil && st.sel.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Value describes the values a path selects in messages of one type.
+type Value struct {
+	// Field is the last field named by the path, or the value field of its
+	// map entry if the path ends in a map key or [*].
+	Field protoreflect.FieldDescriptor
+	// Element is true when the path ends in a list index or [*], so each
+	// value is one element of the repeated Field.
+	Element bool
+}
+
+// Singular reports whether each value selected is a single scalar or
+// message, rather than a whole list or map.
+func (v Value) Singular() bool {
+	return v.Element || (!v.Field.IsList() && !v.Field.IsMap())
+}
+
+// Lookup checks the path against messages of type md and describes what it
+// selects.
+func (p *Path) Lookup(md protoreflect.MessageDescriptor) (Value, error) {
+	var v Value
+	for i, st := range p.steps {
+		if i > 0 {
+			if !v.Singular() || v.Field.Message() == nil {
+				return Value{}, fmt.Errorf("fieldpath: %q: %s is not a singular message field", p.raw, v.Field.FullName())
+			}
+			md = v.Field.Message()
+		}
+		fd := md.Fields().ByName(st.name)
+		if fd == nil {
+			return Value{}, fmt.Errorf("fieldpath: %q: %s has no field %q", p.raw, md.FullName(), st.name)
+		}
+		v = Value{Field: fd}
+		if st.sel == nil {
+			continue
+		}
+		switch {
+		case fd.IsList():
+			if _, err := st.sel.index(); err != nil {
+				return Value{}, fmt.Errorf("fieldpath: %q: %w", p.raw, err)
+			}
+			v.Element = true
+		case fd.IsMap():
+			if _, err := st.sel.mapKey(fd.MapKey()); err != nil {
+				return Value{}, fmt.Errorf("fieldpath: %q: %w", p.raw, err)
+			}
+			v.Field = fd.MapValue()
+		default:
+			return Value{}, fmt.Errorf("fieldpath: %q: [] on %s, which is not repeated or a map", p.raw, fd.FullName())
+		}
+	}
+	return v, nil
+}
+
+func (s *selector) index() (int, error) {
+	if s.wildcard {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(s.lit)
+	if s.quoted || err != nil || n < 0 {
+		return 0, fmt.Errorf("list index must be [*] or a number, got [%s]", s.lit)
+	}
+	return n, nil
+}
+
+func (s *selector) mapKey(fd protoreflect.FieldDescriptor) (protoreflect.MapKey, error) {
+	if s.wildcard {
+		return protoreflect.MapKey{}, nil
+	}
+	var v protoreflect.Value
+	var err error
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		if !s.quoted {
+			return protoreflect.MapKey{}, fmt.Errorf("string map key must be quoted, got [%s]", s.lit)
+		}
+		v = protoreflect.ValueOfString(s.lit)
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s.lit)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(s.lit, 10, 32)
+		v = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(s.lit, 10, 64)
+		v = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s.lit, 10, 32)
+		v = protoreflect.ValueOfUint32(uint32(n))
+	default:
+		var n uint64
+		n, err = strconv.ParseUint(s.lit, 10, 64)
+		v = protoreflect.ValueOfUint64(n)
+	}
+	if err != nil || (s.quoted && fd.Kind() != protoreflect.StringKind) {
+		return protoreflect.MapKey{}, fmt.Errorf("[%s] is not a valid %s map key", s.lit, fd.Kind())
+	}
+	return v.MapKey(), nil
+}
+
+// Values returns every value the path selects in msg, following the rules
+// for reading described in the package documentation.
+func (p *Path) Values(msg protoreflect.Message) ([]protoreflect.Value, error) {
+	var vs []protoreflect.Value
+	err := p.walk(msg, read, func(r Ref) { vs = append(vs, r.Get()) })
+	return vs, err
+}
+
+// Refs returns a Ref for every location the path selects in msg.  With create
+// set, missing messages and map entries along the path are created as
+// described in the package documentation; otherwise only locations that
+// already exist are returned.
+func (p *Path) Refs(msg protoreflect.Message, create bool) ([]Ref, error) {
+	m := existing
+	if create {
+		m = write
+	}
+	var refs []Ref
+	err := p.walk(msg, m, func(r Ref) { refs = append(refs, r) })
+	return refs, err
+}
+
+type mode int
+
+const (
+	// read walks into unset singular messages as empty, read-only ones.
+	read mode = iota
+	// existing only visits messages that are already set.
+	existing
+	// write creates what is missing.
+	write
+)
+
+func (p *Path) walk(msg protoreflect.Message, m mode, fn func(Ref)) error {
+	if _, err := p.Lookup(msg.Descriptor()); err != nil {
+		return err
+	}
+	p.step(msg, 0, m, fn)
+	return nil
+}
+
+func (p *Path) step(msg protoreflect.Message, i int, m mode, fn func(Ref)) {
+	st := p.steps[i]
+	fd := msg.Descriptor().Fields().ByName(st.name)
+	last := i == len(p.steps)-1
+
+	if oo := fd.ContainingOneof(); oo != nil && !oo.IsSynthetic() && m != write && msg.WhichOneof(oo) != fd {
+		return
+	}
+
+	// next continues the walk into a message, or hands the final location to fn.
+	next := func(r Ref, child func() protoreflect.Message) {
+		if last {
+			fn(r)
+			return
+		}
+		p.step(child(), i+1, m, fn)
+	}
+
+	switch {
+	case st.sel == nil:
+		if !last && !msg.Has(fd) && m == existing {
+			return
+		}
+		next(Ref{msg: msg, fd: fd}, func() protoreflect.Message {
+			if m == write {
+				return msg.Mutable(fd).Message()
+			}
+			return msg.Get(fd).Message()
+		})
+
+	case fd.IsList():
+		if !msg.Has(fd) {
+			return
+		}
+		var list protoreflect.List
+		if m == read {
+			list = msg.Get(fd).List()
+		} else {
+			list = msg.Mutable(fd).List()
+		}
+		n, _ := st.sel.index()
+		for j := 0; j < list.Len(); j++ {
+			if n >= 0 && j != n {
+				continue
+			}
+			next(Ref{list: list, index: j}, func() protoreflect.Message {
+				return list.Get(j).Message()
+			})
+		}
+
+	default: // map
+		if !msg.Has(fd) && m != write {
+			return
+		}
+		var mp protoreflect.Map
+		if m == read {
+			mp = msg.Get(fd).Map()
+		} else {
+			mp = msg.Mutable(fd).Map()
+		}
+		visit := func(k protoreflect.MapKey) {
+			next(Ref{m: mp, key: k}, func() protoreflect.Message {
+				if m == read {
+					return mp.Get(k).Message()
+				}
+				return mp.Mutable(k).Message()
+			})
+		}
+		if st.sel.wildcard {
+			var keys []protoreflect.MapKey
+			mp.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			for _, k := range keys {
+				visit(k)
+			}
+			return
+		}
+		k, _ := st.sel.mapKey(fd.MapKey())
+		if !mp.Has(k) && m != write {
+			return
+		}
+		visit(k)
+	}
+}
+
+// Ref is one location selected by a path: a field of a message, an element
+// of a list or the value for a map key.
+type Ref struct {
+	msg protoreflect.Message
+	fd  protoreflect.FieldDescriptor
+
+	list  protoreflect.List
+	index int
+
+	m   protoreflect.Map
+	key protoreflect.MapKey
+}
+
+// Has reports whether the location holds a value: the field is set, the list
+// element exists or the map has the key.
+func (r Ref) Has() bool {
+	switch {
+	case r.list != nil:
+		return true
+	case r.m != nil:
+		return r.m.Has(r.key)
+	}
+	return r.msg.Has(r.fd)
+}
+
+// Get returns the value at the location.
+func (r Ref) Get() protoreflect.Value {
+	switch {
+	case r.list != nil:
+		return r.list.Get(r.index)
+	case r.m != nil:
+		if !r.m.Has(r.key) {
+			return r.m.NewValue()
+		}
+		return r.m.Get(r.key)
+	}
+	return r.msg.Get(r.fd)
+}
+
+// Set stores v at the location.
+func (r Ref) Set(v protoreflect.Value) {
+	switch {
+	case r.list != nil:
+		r.list.Set(r.index, v)
+	case r.m != nil:
+		r.m.Set(r.key, v)
+	default:
+		r.msg.Set(r.fd, v)
+	}
+}
+
+// Clear clears a field or removes a map entry.  A list element is reset to
+// its zero value rather than removed, so the indexes of the others are kept.
+func (r Ref) Clear() {
+	switch {
+	case r.list != nil:
+		r.list.Set(r.index, r.list.NewElement())
+	case r.m != nil:
+		r.m.Clear(r.key)
+	default:
+		r.msg.Clear(r.fd)
+	}
+}
+
+// Append adds v to the end of a repeated field.  It panics if the location
+// is not a repeated field.
+func (r Ref) Append(v protoreflect.Value) {
+	r.msg.Mutable(r.fd).List().Append(v)
+}
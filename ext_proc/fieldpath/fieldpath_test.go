@@ -0,0 +1,284 @@
+package fieldpath
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testProto = `
+name: "test.proto"
+package: "test"
+syntax: "proto3"
+message_type {
+  name: "Order"
+  field { name: "card" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".test.Card" json_name: "card" }
+  field { name: "items" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".test.Item" json_name: "items" }
+  field { name: "labels" number: 3 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".test.Order.LabelsEntry" json_name: "labels" }
+  field { name: "counts" number: 4 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".test.Order.CountsEntry" json_name: "counts" }
+  field { name: "card_pay" number: 5 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".test.Card" oneof_index: 0 json_name: "cardPay" }
+  field { name: "cash" number: 6 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 json_name: "cash" }
+  field { name: "note" number: 7 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "note" }
+  nested_type {
+    name: "LabelsEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "key" }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "value" }
+    options { map_entry: true }
+  }
+  nested_type {
+    name: "CountsEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32 json_name: "key" }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_INT64 json_name: "value" }
+    options { map_entry: true }
+  }
+  oneof_decl { name: "pay" }
+}
+message_type {
+  name: "Card"
+  field { name: "number" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "number" }
+}
+message_type {
+  name: "Item"
+  field { name: "sku" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "sku" }
+}
+`
+
+func orderDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	fdp := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal([]byte(testProto), fdp); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Messages().ByName("Order")
+}
+
+// order returns an Order read from text.
+func order(t *testing.T, text string) *dynamicpb.Message {
+	t.Helper()
+	m := dynamicpb.NewMessage(orderDescriptor(t))
+	if err := prototext.Unmarshal([]byte(text), m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func mustParse(t *testing.T, s string) *Path {
+	t.Helper()
+	p, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		".card",
+		"card.",
+		"card..number",
+		"items[",
+		"items[]",
+		"items[0]x",
+		`labels["env]`,
+		`labels["env"`,
+		"1card",
+		"card-number",
+	}
+	for _, s := range tests {
+		if p, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", s, p)
+		}
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	md := orderDescriptor(t)
+	tests := []struct {
+		path, want string
+	}{
+		{"nope", "has no field"},
+		{"card.nope", "has no field"},
+		{"note.x", "not a singular message"},
+		{"items.sku", "not a singular message"},
+		{"note[0]", "not repeated or a map"},
+		{"items[x]", "list index"},
+		{`items["0"]`, "list index"},
+		{"labels[env]", "must be quoted"},
+		{`counts["1"]`, "not a valid"},
+		{"counts[x]", "not a valid"},
+	}
+	for _, tc := range tests {
+		_, err := mustParse(t, tc.path).Lookup(md)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Lookup(%q) = %v, want an error containing %q", tc.path, err, tc.want)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	msg := order(t, `
+card { number: "4111" }
+items { sku: "a" }
+items { sku: "b" }
+labels { key: "env" value: "prod" }
+labels { key: "a.b[c]" value: "odd" }
+counts { key: 42 value: 7 }
+cash: "10"
+`)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"card.number", []string{"4111"}},
+		{"items[*].sku", []string{"a", "b"}},
+		{"items[1].sku", []string{"b"}},
+		{"items[5].sku", nil},
+		{`labels["env"]`, []string{"prod"}},
+		{`labels["a.b[c]"]`, []string{"odd"}},
+		{`labels["missing"]`, nil},
+		{"counts[42]", []string{"7"}},
+		{"cash", []string{"10"}},
+		// card_pay is not the member of pay that is set
+		{"card_pay.number", nil},
+	}
+	for _, tc := range tests {
+		vs, err := mustParse(t, tc.path).Values(msg)
+		if err != nil {
+			t.Fatalf("Values(%q): %v", tc.path, err)
+		}
+		var got []string
+		for _, v := range vs {
+			got = append(got, v.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Values(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	vs, err := mustParse(t, `labels[*]`).Values(msg)
+	if err != nil || len(vs) != 2 {
+		t.Errorf(`Values("labels[*]") = %v, %v, want 2 values`, vs, err)
+	}
+}
+
+func TestValuesThroughUnset(t *testing.T) {
+	msg := order(t, ``)
+	vs, err := mustParse(t, "card.number").Values(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].String() != "" {
+		t.Errorf("Values(card.number) = %v, want the default", vs)
+	}
+	if msg.Has(msg.Descriptor().Fields().ByName("card")) {
+		t.Error("reading card.number set card")
+	}
+
+	// a unset oneof member selects nothing and is not set by reading
+	vs, err = mustParse(t, "card_pay.number").Values(msg)
+	if err != nil || len(vs) != 0 {
+		t.Errorf("Values(card_pay.number) = %v, %v, want nothing", vs, err)
+	}
+	if msg.WhichOneof(msg.Descriptor().Oneofs().ByName("pay")) != nil {
+		t.Error("reading card_pay.number set pay")
+	}
+
+	refs, err := mustParse(t, "card.number").Refs(msg, false)
+	if err != nil || len(refs) != 0 {
+		t.Errorf("Refs(card.number, false) = %v, %v, want nothing", refs, err)
+	}
+}
+
+func TestRefsCreate(t *testing.T) {
+	tests := []struct {
+		start, path, value, want string
+	}{
+		{``, "card.number", "1", `card{number:"1"}`},
+		{``, `labels["env"]`, "dev", `labels{key:"env" value:"dev"}`},
+		{`cash: "10"`, "card_pay.number", "2", `card_pay{number:"2"}`},
+		{`items{sku:"a"} items{sku:"b"}`, "items[*].sku", "x", `items{sku:"x"} items{sku:"x"}`},
+		// an index past the end is not created
+		{`items{sku:"a"}`, "items[3].sku", "x", `items{sku:"a"}`},
+	}
+	for _, tc := range tests {
+		msg := order(t, tc.start)
+		refs, err := mustParse(t, tc.path).Refs(msg, true)
+		if err != nil {
+			t.Fatalf("Refs(%q): %v", tc.path, err)
+		}
+		for _, r := range refs {
+			r.Set(protoreflect.ValueOfString(tc.value))
+		}
+		want := order(t, tc.want)
+		if !equal(msg, want) {
+			t.Errorf("set %s in {%s} = {%v}, want {%s}", tc.path, tc.start, prototext.Format(msg), tc.want)
+		}
+	}
+}
+
+func equal(a, b *dynamicpb.Message) bool {
+	return prototext.MarshalOptions{}.Format(a) == prototext.MarshalOptions{}.Format(b)
+}
+
+func TestRefClear(t *testing.T) {
+	msg := order(t, `items{sku:"a"} items{sku:"b"} labels{key:"env" value:"prod"}`)
+	for _, path := range []string{"items[0]", `labels["env"]`} {
+		refs, err := mustParse(t, path).Refs(msg, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range refs {
+			r.Clear()
+		}
+	}
+	if want := order(t, `items{} items{sku:"b"}`); !equal(msg, want) {
+		t.Errorf("after Clear = {%v}, want {%v}", prototext.Format(msg), prototext.Format(want))
+	}
+}
+
+func TestAppend(t *testing.T) {
+	msg := order(t, ``)
+	refs, err := mustParse(t, "items").Refs(msg, true)
+	if err != nil || len(refs) != 1 {
+		t.Fatalf("Refs(items) = %v, %v", refs, err)
+	}
+	item := dynamicpb.NewMessage(orderDescriptor(t).ParentFile().Messages().ByName("Item"))
+	refs[0].Append(protoreflect.ValueOfMessage(item))
+	if n := msg.Get(msg.Descriptor().Fields().ByName("items")).List().Len(); n != 1 {
+		t.Errorf("items has %d elements, want 1", n)
+	}
+
+	refs, err = mustParse(t, "note").Refs(msg, true)
+	if err != nil || len(refs) != 1 {
+		t.Fatalf("Refs(note) = %v, %v", refs, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Append to a string field did not panic")
+		}
+	}()
+	refs[0].Append(protoreflect.ValueOfString("x"))
+}
+
+func TestWildcard(t *testing.T) {
+	for path, want := range map[string]bool{
+		"items[*].sku": true,
+		"items[0].sku": false,
+		"card.number":  false,
+		`labels[*]`:    true,
+	} {
+		if got := mustParse(t, path).Wildcard(); got != want {
+			t.Errorf("%s: Wildcard = %t, want %t", path, got, want)
+		}
+	}
+}
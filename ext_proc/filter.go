@@ -1,13 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -16,22 +18,64 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 
-	"github.com/salrashid123/envoy_grpc_decode/echo"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/compression"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/config"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/frame"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/metrics"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/redact"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
 
 	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
-	"github.com/psanford/lencode"
 
-	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 var (
 	grpcport = flag.String("grpcport", ":18080", "grpcport")
 	tlsCert  = flag.String("tlsCert", "../certs/ext_server.crt", "tls Certificate")
 	tlsKey   = flag.String("tlsKey", "../certs/ext_server.key", "tls Key")
+
+	maxMessageSize = flag.Uint("maxMessageSize", frame.DefaultMaxMessageSize, "largest gRPC message (in bytes) the filter will decode")
+	bodyMode       = flag.String("bodyMode", "buffered", "how envoy sends message bodies to the filter: buffered, streamed or full_duplex_streamed")
+	onMalformed    = flag.String("onMalformed", "passthrough", "what to do with a message that cannot be decoded: passthrough, reject (with INVALID_ARGUMENT) or abort (end the ext_proc stream with an error)")
+	descriptorSets = flag.String("descriptorSets", "../grpc_server/echo/echo.proto.pb", "comma separated list of FileDescriptorSet files describing the services to decode")
+	rulesFiles     = flag.String("rules", "rules.yaml", "comma separated list of YAML files with the rules to apply to decoded messages")
+
+	sensitiveOption   = flag.String("sensitiveOption", "privacy.sensitive", "full name of the bool field option marking fields to redact from logs and responses; empty disables")
+	sensitiveStrategy = flag.String("sensitiveStrategy", "hash", "how fields marked with --sensitiveOption are redacted: last4, hash or clear")
+	metadataNamespace = flag.String("metadataNamespace", "envoy_grpc_decode", "namespace of the dynamic metadata the filter sends to envoy")
+
+	reflectionAddress    = flag.String("reflectionAddress", "", "host:port of an upstream serving grpc.reflection.v1, used for methods not in --descriptorSets")
+	reflectionCACert     = flag.String("reflectionCACert", "../certs/root-ca.crt", "tls CA Certificate for --reflectionAddress")
+	reflectionServerName = flag.String("reflectionServerName", "grpc.domain.com", "SNI and SAN to expect from --reflectionAddress")
+	reflectionTimeout    = flag.Duration("reflectionTimeout", schema.DefaultReflectionTimeout, "how long to wait for --reflectionAddress to describe a service")
+	reflectionNegTTL     = flag.Duration("reflectionNegativeTTL", schema.DefaultNegativeTTL, "how long to remember a service --reflectionAddress does not know; 0 asks every time")
+
+	reloadInterval = flag.Duration("reloadInterval", 5*time.Second, "how often to check --descriptorSets and --rules for changes; 0 disables reloading")
+	metricsAddress = flag.String("metricsAddress", ":18090", "address to serve prometheus /metrics on; empty disables")
 )
 
-const ()
+var (
+	// bodySendMode is the ProcessingMode requested for request and response bodies, set from --bodyMode.
+	bodySendMode v3.ProcessingMode_BodySendMode
+
+	// malformedPolicy is what is done with a message that cannot be decoded, set from --onMalformed.
+	malformedPolicy string
+
+	// configWatcher holds the current descriptor sets and rules; each stream uses the snapshot current when it opened.
+	configWatcher *config.Watcher
+
+	// reflectionSource is consulted for methods missing from the descriptor sets; nil unless --reflectionAddress is set.
+	reflectionSource *schema.ReflectionSource
+)
+
+// The --onMalformed policies.
+const (
+	malformedPassthrough = "passthrough"
+	malformedReject      = "reject"
+	malformedAbort       = "abort"
+)
 
 type server struct{}
 
@@ -46,245 +90,155 @@ func (s *healthServer) Watch(in *healthpb.HealthCheckRequest, srv healthpb.Healt
 	return status.Error(codes.Unimplemented, "Watch is not implemented")
 }
 
-func (s *server) Process(srv pb.ExternalProcessor_ProcessServer) error {
-
-	log.Println("Got stream:  -->  ")
-	ctx := srv.Context()
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-		req, err := srv.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
-		}
+func (s *healthServer) List(ctx context.Context, in *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	return &healthpb.HealthListResponse{
+		Statuses: map[string]*healthpb.HealthCheckResponse{
+			"": {Status: healthpb.HealthCheckResponse_SERVING},
+		},
+	}, nil
+}
 
-		resp := &pb.ProcessingResponse{}
-		switch v := req.Request.(type) {
-		case *pb.ProcessingRequest_RequestHeaders:
-			log.Printf("pb.ProcessingRequest_RequestHeaders %v \n", v)
-			r := req.Request
-			h := r.(*pb.ProcessingRequest_RequestHeaders)
-			log.Printf("Got RequestHeaders.Attributes %v", h.RequestHeaders.Attributes)
-			log.Printf("Got RequestHeaders.Headers %v", h.RequestHeaders.Headers)
-
-			for _, n := range h.RequestHeaders.Headers.Headers {
-				if n.Key == ":method" && n.Value == "POST" {
-					for _, n := range h.RequestHeaders.Headers.Headers {
-						log.Printf("Header %s %s", n.Key, n.Value)
-
-						rhq := &pb.HeadersResponse{
-							Response: &pb.CommonResponse{},
-						}
-
-						resp = &pb.ProcessingResponse{
-							Response: &pb.ProcessingResponse_RequestHeaders{
-								RequestHeaders: rhq,
-							},
-							ModeOverride: &v3.ProcessingMode{
-								RequestBodyMode:    v3.ProcessingMode_BUFFERED,
-								ResponseHeaderMode: v3.ProcessingMode_SKIP,
-								ResponseBodyMode:   v3.ProcessingMode_NONE,
-							},
-						}
-						break
-
-					}
-
-				}
-			}
+// messagePayload returns the serialized message carried by f, decompressing
+// it with the stream's grpc-encoding when the compressed flag is set.
+func messagePayload(f *frame.Frame, encoding string) ([]byte, error) {
+	if !f.Compressed {
+		return f.Payload, nil
+	}
+	if encoding == "" || encoding == compression.Identity {
+		return nil, fmt.Errorf("compressed flag set but grpc-encoding is %q", encoding)
+	}
+	return compression.Decompress(encoding, f.Payload, int(*maxMessageSize))
+}
 
-		case *pb.ProcessingRequest_RequestBody:
-
-			r := req.Request
-			b := r.(*pb.ProcessingRequest_RequestBody)
-			log.Printf("   RequestBody: %s", string(b.RequestBody.Body))
-			log.Printf("   EndOfStream: %T", b.RequestBody.EndOfStream)
-			if b.RequestBody.EndOfStream {
-
-				dec := lencode.NewDecoder(bytes.NewBuffer(b.RequestBody.Body), lencode.SeparatorOpt([]byte{0}))
-
-				reqMessageBytes, err := dec.Decode()
-
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					log.Fatalf("could not Decode  %v", err)
-					return err
-				}
-				er := &echo.EchoRequest{}
-
-				err = proto.Unmarshal(reqMessageBytes, er)
-				if err != nil {
-					log.Fatal("unmarshaling error: ", err)
-				}
-
-				if er.Name == "alice" {
-					fmt.Printf("Decode echo.EchoRequest payload ---->  %v\n", string(er.Name))
-
-					var out bytes.Buffer
-					enc := lencode.NewEncoder(&out, lencode.SeparatorOpt([]byte{0}))
-					if err != nil {
-						log.Printf("Error NewEncoder: %v\n", err)
-						return err
-					}
-
-					enew := &echo.EchoRequest{
-						Name: "bob",
-					}
-
-					bb, err := proto.Marshal(enew)
-					if err != nil {
-						log.Printf("Error Marshalling response: %v\n", err)
-						return err
-					}
-
-					err = enc.Encode(bb)
-					if err != nil {
-						log.Printf("Error NewEncoder.Encode: %v\n", err)
-						return err
-					}
-
-					resp = &pb.ProcessingResponse{
-						Response: &pb.ProcessingResponse_RequestBody{
-							RequestBody: &pb.BodyResponse{
-								Response: &pb.CommonResponse{
-									BodyMutation: &pb.BodyMutation{
-										Mutation: &pb.BodyMutation_Body{
-											Body: out.Bytes(),
-										},
-									},
-								},
-							},
-						},
-
-						ModeOverride: &v3.ProcessingMode{
-							ResponseHeaderMode: v3.ProcessingMode_SEND,
-							ResponseBodyMode:   v3.ProcessingMode_NONE,
-						},
-					}
-
-				} else {
-					resp = &pb.ProcessingResponse{
-						Response: &pb.ProcessingResponse_RequestBody{},
-						ModeOverride: &v3.ProcessingMode{
-							ResponseHeaderMode: v3.ProcessingMode_SEND,
-							ResponseBodyMode:   v3.ProcessingMode_NONE,
-						},
-					}
-				}
+// newFrame frames a serialized message, compressing it with the stream's
+// grpc-encoding if the message it replaces was compressed.
+func newFrame(payload []byte, compressed bool, encoding string) (*frame.Frame, error) {
+	if !compressed {
+		return &frame.Frame{Payload: payload}, nil
+	}
+	b, err := compression.Compress(encoding, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &frame.Frame{Compressed: true, Payload: b}, nil
+}
 
-			}
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
 
-		case *pb.ProcessingRequest_ResponseHeaders:
-			log.Printf("pb.ProcessingRequest_ResponseHeaders %v \n", v)
-			rhq := &pb.HeadersResponse{}
-			resp = &pb.ProcessingResponse{
-				Response: &pb.ProcessingResponse_ResponseHeaders{
-					ResponseHeaders: rhq,
-				},
-				ModeOverride: &v3.ProcessingMode{
-					ResponseBodyMode: v3.ProcessingMode_BUFFERED,
-				},
-			}
+func (s *server) Process(srv pb.ExternalProcessor_ProcessServer) error {
 
-		case *pb.ProcessingRequest_ResponseBody:
-			log.Printf("pb.ProcessingRequest_ResponseBody %v \n", v)
-
-			r := req.Request
-			b := r.(*pb.ProcessingRequest_ResponseBody)
-			log.Printf("   ResponseBody: %s", string(b.ResponseBody.Body))
-			log.Printf("   EndOfStream: %T", b.ResponseBody.EndOfStream)
-			if b.ResponseBody.EndOfStream {
-				enc := lencode.NewDecoder(bytes.NewBuffer(b.ResponseBody.Body), lencode.SeparatorOpt([]byte{0}))
-
-				var bytesToSend []byte
-				for {
-					respMessageBytes, err := enc.Decode()
-
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						log.Fatalf("could not Decode  %v", err)
-						return err
-					}
-
-					er := &echo.EchoReply{}
-
-					err = proto.Unmarshal(respMessageBytes, er)
-					if err != nil {
-						log.Fatal("unmarshaling error: ", err)
-					}
-
-					if er.Message == "hi carol" {
-						fmt.Printf("Decoded echo.EchoReply message [%s]\n", er.Message)
-						var out bytes.Buffer
-						enc := lencode.NewEncoder(&out, lencode.SeparatorOpt([]byte{0}))
-						if err != nil {
-							log.Printf("Error NewEncoder: %v\n", err)
-							return err
-						}
-
-						enew := &echo.EchoReply{
-							Message: "hi sally",
-						}
-
-						bb, err := proto.Marshal(enew)
-						if err != nil {
-							log.Printf("Error Marshalling response: %v\n", err)
-							return err
-						}
-
-						err = enc.Encode(bb)
-						if err != nil {
-							log.Printf("Error NewEncoder.Encode: %v\n", err)
-							return err
-						}
-						bytesToSend = append(bytesToSend, out.Bytes()...)
-
-					}
-
-				}
-
-				if len(bytesToSend) == 0 {
-					resp = &pb.ProcessingResponse{}
-				} else {
-					resp = &pb.ProcessingResponse{
-						Response: &pb.ProcessingResponse_ResponseBody{
-							ResponseBody: &pb.BodyResponse{
-								Response: &pb.CommonResponse{
-									BodyMutation: &pb.BodyMutation{
-										Mutation: &pb.BodyMutation_Body{
-											Body: bytesToSend,
-										},
-									},
-								},
-							},
-						},
-					}
-				}
+	log.Println("Got stream:  -->  ")
+	ctx, cancel := context.WithCancel(srv.Context())
+	defer cancel()
+
+	snap := configWatcher.Current()
+	log.Printf("Using config version %s", snap.Version)
+	st := newStream(snap, reflectionSource)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 3)
+	run := func(fn func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx); err != nil {
+				errc <- err
+				cancel()
 			}
-
-		default:
-			log.Printf("Unknown Request type %v\n", v)
-		}
+		}()
+	}
+	run(func(ctx context.Context) error { return st.receive(ctx, srv) })
+	run(st.request.run)
+	run(st.response.run)
+	go func() {
+		wg.Wait()
+		close(st.out)
+	}()
+
+	for resp := range st.out {
 		if err := srv.Send(resp); err != nil {
 			log.Printf("send error %v", err)
+			return err
 		}
 	}
+
+	select {
+	case err := <-errc:
+		return err
+	default:
+		return nil
+	}
 }
 
 func main() {
 
 	flag.Parse()
 
+	switch *bodyMode {
+	case "buffered":
+		bodySendMode = v3.ProcessingMode_BUFFERED
+	case "streamed":
+		bodySendMode = v3.ProcessingMode_STREAMED
+	case "full_duplex_streamed":
+		bodySendMode = v3.ProcessingMode_FULL_DUPLEX_STREAMED
+	default:
+		log.Fatalf("--bodyMode must be buffered, streamed or full_duplex_streamed, got %q", *bodyMode)
+	}
+
+	switch *onMalformed {
+	case malformedPassthrough, malformedReject, malformedAbort:
+		malformedPolicy = *onMalformed
+	default:
+		log.Fatalf("--onMalformed must be passthrough, reject or abort, got %q", *onMalformed)
+	}
+
+	strategy, err := redact.ParseStrategy(*sensitiveStrategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configWatcher, err = config.NewWatcher(config.Sources{
+		DescriptorSets:    splitList(*descriptorSets),
+		Rules:             splitList(*rulesFiles),
+		SensitiveOption:   protoreflect.FullName(*sensitiveOption),
+		SensitiveStrategy: strategy,
+	})
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if *reloadInterval > 0 {
+		go configWatcher.Run(context.Background(), *reloadInterval)
+	}
+
+	if *metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddress, mux))
+		}()
+	}
+
+	if *reflectionAddress != "" {
+		rce, err := credentials.NewClientTLSFromFile(*reflectionCACert, *reflectionServerName)
+		if err != nil {
+			log.Fatalf("Failed to generate reflection credentials %v", err)
+		}
+		conn, err := grpc.NewClient(*reflectionAddress, grpc.WithTransportCredentials(rce))
+		if err != nil {
+			log.Fatalf("did not connect to reflection server: %v", err)
+		}
+		defer conn.Close()
+		reflectionSource = schema.NewReflectionSource(conn, schema.ReflectionTimeoutOpt(*reflectionTimeout), schema.NegativeTTLOpt(*reflectionNegTTL))
+	}
+
 	lis, err := net.Listen("tcp", *grpcport)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
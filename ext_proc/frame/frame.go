@@ -0,0 +1,207 @@
+// Package frame reads and writes gRPC length-prefixed messages.
+//
+// Every message on a gRPC stream is sent as
+//
+//	Compressed-Flag (1 byte) | Message-Length (4 bytes, big-endian) | Message
+//
+// as described in https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// HeaderSize is the size of the prefix in front of every gRPC message.
+	HeaderSize = 5
+
+	// DefaultMaxMessageSize matches the default receive limit of grpc-go.
+	DefaultMaxMessageSize = 4 * 1024 * 1024
+)
+
+var (
+	// ErrTruncated is returned when the input ends in the middle of a frame.
+	ErrTruncated = errors.New("frame: truncated gRPC frame")
+	// ErrTooLarge is returned when a frame declares a length above the configured maximum.
+	ErrTooLarge = errors.New("frame: gRPC message exceeds maximum size")
+	// ErrInvalidFlag is returned when the compressed flag is neither 0 nor 1.
+	ErrInvalidFlag = errors.New("frame: invalid compressed flag")
+)
+
+// Frame is a single gRPC message together with its compressed flag.
+type Frame struct {
+	Compressed bool
+	Payload    []byte
+}
+
+// Decoder reads successive frames from an io.Reader.
+type Decoder struct {
+	r              io.Reader
+	maxMessageSize uint32
+}
+
+// DecoderOpt configures a Decoder.
+type DecoderOpt func(*Decoder)
+
+// MaxMessageSizeOpt sets the largest message length the decoder accepts.
+func MaxMessageSizeOpt(n uint32) DecoderOpt {
+	return func(d *Decoder) {
+		d.maxMessageSize = n
+	}
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader, opts ...DecoderOpt) *Decoder {
+	d := &Decoder{
+		r:              r,
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Decode returns the next frame. It returns io.EOF when the input ends
+// cleanly on a frame boundary and ErrTruncated when it does not.
+func (d *Decoder) Decode() (*Frame, error) {
+	var hdr [HeaderSize]byte
+	n, err := io.ReadFull(d.r, hdr[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: header has %d of %d bytes", ErrTruncated, n, HeaderSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, length, err := parseHeader(hdr[:], d.maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Payload = make([]byte, length)
+	n, err = io.ReadFull(d.r, f.Payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: message has %d of %d bytes", ErrTruncated, n, length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseHeader(hdr []byte, maxMessageSize uint32) (*Frame, uint32, error) {
+	f := &Frame{}
+	switch hdr[0] {
+	case 0:
+	case 1:
+		f.Compressed = true
+	default:
+		return nil, 0, fmt.Errorf("%w: %d", ErrInvalidFlag, hdr[0])
+	}
+	length := binary.BigEndian.Uint32(hdr[1:HeaderSize])
+	if length > maxMessageSize {
+		return nil, 0, fmt.Errorf("%w: %d > %d", ErrTooLarge, length, maxMessageSize)
+	}
+	return f, length, nil
+}
+
+// Encoder writes frames to an io.Writer.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes f with its 5-byte prefix.
+func (e *Encoder) Encode(f *Frame) error {
+	b, err := Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Marshal returns the wire encoding of f.
+func Marshal(f *Frame) ([]byte, error) {
+	if uint64(len(f.Payload)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(f.Payload))
+	}
+	b := make([]byte, HeaderSize+len(f.Payload))
+	if f.Compressed {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint32(b[1:HeaderSize], uint32(len(f.Payload)))
+	copy(b[HeaderSize:], f.Payload)
+	return b, nil
+}
+
+// Reassembler extracts complete frames from a body that arrives in chunks,
+// carrying any partial frame over to the next chunk.
+type Reassembler struct {
+	buf            []byte
+	maxMessageSize uint32
+}
+
+// NewReassembler returns an empty Reassembler.
+func NewReassembler(opts ...DecoderOpt) *Reassembler {
+	d := NewDecoder(nil, opts...)
+	return &Reassembler{maxMessageSize: d.maxMessageSize}
+}
+
+// Write appends chunk and returns every frame completed by it, in order.
+func (r *Reassembler) Write(chunk []byte) ([]*Frame, error) {
+	r.buf = append(r.buf, chunk...)
+	var frames []*Frame
+	for len(r.buf) >= HeaderSize {
+		f, length, err := parseHeader(r.buf[:HeaderSize], r.maxMessageSize)
+		if err != nil {
+			return frames, err
+		}
+		end := HeaderSize + int(length)
+		if len(r.buf) < end {
+			break
+		}
+		f.Payload = make([]byte, length)
+		copy(f.Payload, r.buf[HeaderSize:end])
+		frames = append(frames, f)
+		r.buf = r.buf[end:]
+	}
+	if len(r.buf) == 0 {
+		r.buf = nil
+	}
+	return frames, nil
+}
+
+// Buffered returns the number of bytes held for an incomplete frame.
+func (r *Reassembler) Buffered() int {
+	return len(r.buf)
+}
+
+// Flush returns the bytes held for an incomplete frame and empties the
+// Reassembler.  After Write fails they start with the frame header that could
+// not be parsed.
+func (r *Reassembler) Flush() []byte {
+	b := r.buf
+	r.buf = nil
+	return b
+}
+
+// Close reports ErrTruncated if the stream ended in the middle of a frame.
+func (r *Reassembler) Close() error {
+	if len(r.buf) > 0 {
+		return fmt.Errorf("%w: %d bytes left at end of stream", ErrTruncated, len(r.buf))
+	}
+	return nil
+}
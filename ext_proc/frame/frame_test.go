@@ -0,0 +1,163 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func marshal(t *testing.T, fs ...*Frame) []byte {
+	t.Helper()
+	var b []byte
+	for _, f := range fs {
+		fb, err := Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, fb...)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []*Frame{
+		{Payload: []byte("hello")},
+		{Compressed: true, Payload: []byte{0x1f, 0x8b, 0x00}},
+		{Payload: []byte{}},
+	}
+	for _, want := range tests {
+		var b bytes.Buffer
+		if err := NewEncoder(&b).Encode(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewDecoder(&b).Decode()
+		if err != nil {
+			t.Fatalf("Decode(%v): %v", want, err)
+		}
+		if got.Compressed != want.Compressed || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("Decode = %+v, want %+v", got, want)
+		}
+		if _, err := NewDecoder(&b).Decode(); err != io.EOF {
+			t.Errorf("Decode at end = %v, want io.EOF", err)
+		}
+	}
+}
+
+func TestMarshalHeader(t *testing.T) {
+	b := marshal(t, &Frame{Compressed: true, Payload: []byte("abc")})
+	want := []byte{1, 0, 0, 0, 3, 'a', 'b', 'c'}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Marshal = %v, want %v", b, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		opts []DecoderOpt
+		want error
+	}{
+		{"short header", []byte{0, 0, 0}, nil, ErrTruncated},
+		{"short message", []byte{0, 0, 0, 0, 4, 'a', 'b'}, nil, ErrTruncated},
+		{"too large", []byte{0, 0, 0, 1, 0}, []DecoderOpt{MaxMessageSizeOpt(255)}, ErrTooLarge},
+		{"bad flag", []byte{2, 0, 0, 0, 0}, nil, ErrInvalidFlag},
+	}
+	for _, tc := range tests {
+		_, err := NewDecoder(bytes.NewReader(tc.in), tc.opts...).Decode()
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: Decode = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestReassembler(t *testing.T) {
+	one := marshal(t, &Frame{Payload: []byte("one")})
+	two := marshal(t, &Frame{Compressed: true, Payload: []byte("two")})
+	both := append(append([]byte{}, one...), two...)
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		want     []string
+		buffered int
+	}{
+		{"one chunk", [][]byte{one}, []string{"one"}, 0},
+		{"several messages in one chunk", [][]byte{both}, []string{"one", "two"}, 0},
+		{"header split across chunks", [][]byte{one[:2], one[2:4], one[4:]}, []string{"one"}, 0},
+		{"message split across chunks", [][]byte{both[:10], both[10:]}, []string{"one", "two"}, 0},
+		{"byte at a time", split(both, 1), []string{"one", "two"}, 0},
+		{"partial frame held", [][]byte{one, two[:6]}, []string{"one"}, 6},
+	}
+	for _, tc := range tests {
+		r := NewReassembler()
+		var got []string
+		for _, c := range tc.chunks {
+			fs, err := r.Write(c)
+			if err != nil {
+				t.Fatalf("%s: Write: %v", tc.name, err)
+			}
+			for _, f := range fs {
+				got = append(got, string(f.Payload))
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: frame %d = %q, want %q", tc.name, i, got[i], tc.want[i])
+			}
+		}
+		if r.Buffered() != tc.buffered {
+			t.Errorf("%s: Buffered = %d, want %d", tc.name, r.Buffered(), tc.buffered)
+		}
+	}
+}
+
+func split(b []byte, n int) [][]byte {
+	var out [][]byte
+	for len(b) > n {
+		out = append(out, b[:n])
+		b = b[n:]
+	}
+	return append(out, b)
+}
+
+func TestReassemblerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		opts []DecoderOpt
+		want error
+	}{
+		{"too large", []byte{0, 0, 0, 1, 0}, []DecoderOpt{MaxMessageSizeOpt(255)}, ErrTooLarge},
+		{"bad flag", []byte{7, 0, 0, 0, 0}, nil, ErrInvalidFlag},
+	}
+	for _, tc := range tests {
+		r := NewReassembler(tc.opts...)
+		if _, err := r.Write(tc.in); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Write = %v, want %v", tc.name, err, tc.want)
+		}
+		if got := r.Flush(); !bytes.Equal(got, tc.in) {
+			t.Errorf("%s: Flush = %v, want the unparsed %v", tc.name, got, tc.in)
+		}
+	}
+}
+
+func TestReassemblerClose(t *testing.T) {
+	r := NewReassembler()
+	if _, err := r.Write([]byte{0, 0, 0, 0, 9, 'x'}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Close = %v, want ErrTruncated", err)
+	}
+	if b := r.Flush(); len(b) != 6 {
+		t.Errorf("Flush returned %d bytes, want 6", len(b))
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close after Flush = %v, want nil", err)
+	}
+}
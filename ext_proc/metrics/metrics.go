@@ -0,0 +1,48 @@
+// Package metrics holds the Prometheus metrics exported by the filter.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "envoy_grpc_decode"
+
+var (
+	// ConfigInfo is 1 for the configuration version currently in use.
+	ConfigInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_info",
+		Help:      "Version of the descriptor sets and rules in use; the active version has value 1.",
+	}, []string{"version"})
+
+	// ConfigReloads counts reload attempts by result (success or failure).
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads, by result.",
+	}, []string{"result"})
+
+	// Denials counts calls ended by a deny action, by rule.
+	Denials = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "denials_total",
+		Help:      "Calls denied by a rule, by rule name.",
+	}, []string{"rule"})
+
+	// MalformedMessages counts messages that could not be decoded, by
+	// direction and by what was done with them (passthrough, reject or abort).
+	MalformedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "malformed_messages_total",
+		Help:      "Messages that could not be decoded, by direction and outcome.",
+	}, []string{"direction", "outcome"})
+)
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
+
+	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+)
+
+// callMode is how envoy is asked to send the rest of one call.
+type callMode struct {
+	requestBody      v3.ProcessingMode_BodySendMode
+	responseBody     v3.ProcessingMode_BodySendMode
+	responseHeaders  v3.ProcessingMode_HeaderSendMode
+	responseTrailers v3.ProcessingMode_HeaderSendMode
+}
+
+// skipMode is for calls nothing is done to: envoy sends nothing more.
+var skipMode = &callMode{
+	requestBody:      v3.ProcessingMode_NONE,
+	responseBody:     v3.ProcessingMode_NONE,
+	responseHeaders:  v3.ProcessingMode_SKIP,
+	responseTrailers: v3.ProcessingMode_SKIP,
+}
+
+// selectMode decides, from the request headers, how envoy should send the
+// rest of the call, and resolves the method being called if its messages
+// are to be decoded.  Only gRPC calls to a known method that a rule, decode
+// condition or sensitive field applies to are decoded; everything else is
+// skipped.
+func (st *stream) selectMode(ctx context.Context, headers map[string]string) *callMode {
+	path := headers[":path"]
+	if headers[":method"] != "POST" || !isGRPC(headers["content-type"]) {
+		log.Printf("Skipping %s: not a gRPC call", path)
+		return skipMode
+	}
+	if !st.config.Rules.Decode(path, st.ruleVars()) {
+		log.Printf("Skipping %s: no decode condition holds", path)
+		return skipMode
+	}
+
+	md, err := st.config.Registry.Method(path)
+	if errors.Is(err, schema.ErrUnknownMethod) && st.reflection != nil {
+		md, err = st.reflection.Method(ctx, path)
+	}
+	if err != nil {
+		log.Printf("Skipping %s: messages cannot be decoded: %v", path, err)
+		return skipMode
+	}
+
+	m := &callMode{
+		requestBody:      bodySendMode,
+		responseBody:     bodySendMode,
+		responseHeaders:  v3.ProcessingMode_SEND,
+		responseTrailers: v3.ProcessingMode_SEND,
+	}
+	if p, ok := st.config.Rules.Processing(path); ok {
+		m.requestBody = parseBodyMode(p.RequestBody, m.requestBody)
+		m.responseBody = parseBodyMode(p.ResponseBody, m.responseBody)
+		m.responseHeaders = parseHeaderMode(p.ResponseHeaders)
+		m.responseTrailers = parseHeaderMode(p.ResponseTrailers)
+	} else if !st.config.Rules.Covers(path) && !extproc.Handled(path) && !st.config.Sensitive.Covers(md.Output()) {
+		log.Printf("Skipping %s: no rules apply", path)
+		return skipMode
+	}
+	st.setMethod(md)
+	return m
+}
+
+// override is the mode_override asking envoy for the rest of the call.
+// Envoy replaces its whole mode with it, and only takes it from replies to
+// headers, so every reply to headers sends all of it.
+// Request trailers are asked for whenever the request body is, as they end
+// it.
+func (m *callMode) override() *v3.ProcessingMode {
+	requestTrailers := v3.ProcessingMode_SKIP
+	if m.requestBody != v3.ProcessingMode_NONE {
+		requestTrailers = v3.ProcessingMode_SEND
+	}
+	return modeOverride(&v3.ProcessingMode{
+		RequestHeaderMode:   v3.ProcessingMode_SEND,
+		RequestBodyMode:     m.requestBody,
+		RequestTrailerMode:  requestTrailers,
+		ResponseHeaderMode:  m.responseHeaders,
+		ResponseBodyMode:    m.responseBody,
+		ResponseTrailerMode: m.responseTrailers,
+	})
+}
+
+// isGRPC reports whether content-type is application/grpc or one of its
+// subtypes, eg application/grpc+proto.
+func isGRPC(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// parseBodyMode returns the body send mode named by s, or def if s is empty.
+func parseBodyMode(s string, def v3.ProcessingMode_BodySendMode) v3.ProcessingMode_BodySendMode {
+	switch s {
+	case "buffered":
+		return v3.ProcessingMode_BUFFERED
+	case "streamed":
+		return v3.ProcessingMode_STREAMED
+	case "none":
+		return v3.ProcessingMode_NONE
+	}
+	return def
+}
+
+// parseHeaderMode returns the header send mode named by s, send by default.
+func parseHeaderMode(s string) v3.ProcessingMode_HeaderSendMode {
+	if s == "skip" {
+		return v3.ProcessingMode_SKIP
+	}
+	return v3.ProcessingMode_SEND
+}
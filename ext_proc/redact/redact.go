@@ -0,0 +1,105 @@
+// Package redact masks sensitive values in decoded messages.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/fieldpath"
+)
+
+// Strategy is how a value is masked.
+type Strategy string
+
+const (
+	// Last4 replaces every character of a string but the last four with '*'.
+	// Strings of four characters or fewer are masked entirely.
+	Last4 Strategy = "last4"
+	// Hash replaces a string or bytes value with the hex SHA-256 of it, so
+	// equal values can still be correlated.
+	Hash Strategy = "hash"
+	// Clear clears the field.
+	Clear Strategy = "clear"
+)
+
+// ParseStrategy returns the named strategy.
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(s); st {
+	case Last4, Hash, Clear:
+		return st, nil
+	}
+	return "", fmt.Errorf("redact: unknown strategy %q, want last4, hash or clear", s)
+}
+
+// Check reports whether s can mask values of fd.
+func (s Strategy) Check(fd protoreflect.FieldDescriptor) error {
+	switch s {
+	case Last4:
+		if fd.Kind() != protoreflect.StringKind {
+			return fmt.Errorf("redact: last4 needs a string field, %s is %s", fd.FullName(), fd.Kind())
+		}
+	case Hash:
+		if fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind {
+			return fmt.Errorf("redact: hash needs a string or bytes field, %s is %s", fd.FullName(), fd.Kind())
+		}
+	}
+	return nil
+}
+
+// Summary counts the values masked at each field path.
+type Summary map[string]Masked
+
+// Masked is the number of values masked at one field path, and how.
+type Masked struct {
+	Strategy Strategy
+	Count    int
+}
+
+// Add records n more values masked at path with strategy.
+func (s Summary) Add(path string, strategy Strategy, n int) {
+	m := s[path]
+	s[path] = Masked{Strategy: strategy, Count: m.Count + n}
+}
+
+// Apply masks the value at r and reports whether there was one to mask.
+func (s Strategy) Apply(r fieldpath.Ref) bool {
+	if !r.Has() {
+		return false
+	}
+	switch s {
+	case Clear:
+		r.Clear()
+	case Last4:
+		r.Set(protoreflect.ValueOfString(last4(r.Get().String())))
+	case Hash:
+		v := r.Get()
+		if b, ok := v.Interface().([]byte); ok {
+			sum := sha256.Sum256(b)
+			r.Set(protoreflect.ValueOfBytes([]byte(hex.EncodeToString(sum[:]))))
+		} else {
+			sum := sha256.Sum256([]byte(v.String()))
+			r.Set(protoreflect.ValueOfString(hex.EncodeToString(sum[:])))
+		}
+	}
+	return true
+}
+
+func last4(s string) string {
+	n := utf8.RuneCountInString(s)
+	if n <= 4 {
+		return strings.Repeat("*", n)
+	}
+	i := 0
+	for j := range s {
+		if i == n-4 {
+			return strings.Repeat("*", n-4) + s[j:]
+		}
+		i++
+	}
+	return s
+}
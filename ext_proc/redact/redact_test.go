@@ -0,0 +1,284 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/fieldpath"
+)
+
+const privacyProto = `
+name: "privacy/privacy.proto"
+package: "privacy"
+syntax: "proto3"
+dependency: "google/protobuf/descriptor.proto"
+extension { name: "sensitive" number: 50000 label: LABEL_OPTIONAL type: TYPE_BOOL extendee: ".google.protobuf.FieldOptions" json_name: "sensitive" }
+`
+
+const cardProto = `
+name: "card.proto"
+package: "test"
+syntax: "proto3"
+dependency: "privacy/privacy.proto"
+message_type {
+  name: "Card"
+  field { name: "number" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "number" }
+  field { name: "holder" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "holder" }
+  field { name: "cvv" number: 3 label: LABEL_OPTIONAL type: TYPE_INT64 json_name: "cvv" }
+  field { name: "tags" number: 4 label: LABEL_REPEATED type: TYPE_STRING json_name: "tags" }
+  field { name: "pin" number: 5 label: LABEL_OPTIONAL type: TYPE_BYTES json_name: "pin" }
+}
+message_type {
+  name: "Wallet"
+  field { name: "card" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".test.Card" json_name: "card" }
+  field { name: "cards" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".test.Card" json_name: "cards" }
+}
+`
+
+// sensitiveOption returns FieldOptions setting field 50000 to v as protoc
+// writes them: in a binary without the extension it is an unknown field.
+func sensitiveOption(v uint64) *descriptorpb.FieldOptions {
+	opts := &descriptorpb.FieldOptions{}
+	b := protowire.AppendTag(nil, 50000, protowire.VarintType)
+	opts.ProtoReflect().SetUnknown(protowire.AppendVarint(b, v))
+	return opts
+}
+
+// testFiles returns card.proto with number, cvv and tags marked sensitive
+// and pin marked not sensitive.  privacy is privacy.proto's text.
+func testFiles(t *testing.T, privacy string) *protoregistry.Files {
+	t.Helper()
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(descriptorpb.File_google_protobuf_descriptor_proto); err != nil {
+		t.Fatal(err)
+	}
+	card := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal([]byte(cardProto), card); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range card.MessageType[0].Field {
+		switch f.GetName() {
+		case "number", "cvv", "tags":
+			f.Options = sensitiveOption(1)
+		case "pin":
+			f.Options = sensitiveOption(0)
+		}
+	}
+	for _, text := range []string{privacy, ""} {
+		fdp := card
+		if text != "" {
+			fdp = &descriptorpb.FileDescriptorProto{}
+			if err := prototext.Unmarshal([]byte(text), fdp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := files.RegisterFile(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func message(t *testing.T, files *protoregistry.Files, name, text string) *dynamicpb.Message {
+	t.Helper()
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+	if err := prototext.Unmarshal([]byte(text), m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func ref(t *testing.T, m protoreflect.Message, path string) fieldpath.Ref {
+	t.Helper()
+	p, err := fieldpath.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := p.Refs(m, true)
+	if err != nil || len(refs) != 1 {
+		t.Fatalf("Refs(%s) = %v, %v", path, refs, err)
+	}
+	return refs[0]
+}
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestStrategies(t *testing.T) {
+	files := testFiles(t, privacyProto)
+	tests := []struct {
+		strategy      Strategy
+		field, in     string
+		want          string
+		masked, isSet bool
+	}{
+		{Last4, "holder", "4111111111111111", "************1111", true, true},
+		{Last4, "holder", "abcd", "****", true, true},
+		{Last4, "holder", "héllo wörld", "*******örld", true, true},
+		{Hash, "holder", "alice", sha("alice"), true, true},
+		{Hash, "pin", "1234", sha("1234"), true, true},
+		{Clear, "holder", "alice", "", true, false},
+		{Last4, "holder", "", "", false, false},
+	}
+	for _, tc := range tests {
+		m := message(t, files, "test.Card", "")
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(tc.field))
+		if tc.in != "" {
+			if fd.Kind() == protoreflect.BytesKind {
+				m.Set(fd, protoreflect.ValueOfBytes([]byte(tc.in)))
+			} else {
+				m.Set(fd, protoreflect.ValueOfString(tc.in))
+			}
+		}
+		if err := tc.strategy.Check(fd); err != nil {
+			t.Fatalf("%s on %s: Check: %v", tc.strategy, tc.field, err)
+		}
+		if got := tc.strategy.Apply(ref(t, m, tc.field)); got != tc.masked {
+			t.Errorf("%s(%q): Apply = %t, want %t", tc.strategy, tc.in, got, tc.masked)
+		}
+		var got string
+		if fd.Kind() == protoreflect.BytesKind {
+			got = string(m.Get(fd).Bytes())
+		} else {
+			got = m.Get(fd).String()
+		}
+		if got != tc.want || m.Has(fd) != tc.isSet {
+			t.Errorf("%s(%q) = %q (set %t), want %q (set %t)", tc.strategy, tc.in, got, m.Has(fd), tc.want, tc.isSet)
+		}
+	}
+}
+
+func TestStrategyCheck(t *testing.T) {
+	md := message(t, testFiles(t, privacyProto), "test.Card", "").Descriptor()
+	tests := []struct {
+		strategy Strategy
+		field    string
+		ok       bool
+	}{
+		{Last4, "number", true},
+		{Last4, "pin", false},
+		{Last4, "cvv", false},
+		{Hash, "pin", true},
+		{Hash, "cvv", false},
+		{Clear, "cvv", true},
+	}
+	for _, tc := range tests {
+		err := tc.strategy.Check(md.Fields().ByName(protoreflect.Name(tc.field)))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s on %s: Check = %v, want ok %t", tc.strategy, tc.field, err, tc.ok)
+		}
+	}
+	if _, err := ParseStrategy("blur"); err == nil {
+		t.Error("ParseStrategy(blur) succeeded")
+	}
+}
+
+func TestSensitiveMarked(t *testing.T) {
+	files := testFiles(t, privacyProto)
+	s := NewSensitive("privacy.sensitive", Last4)
+	n, err := s.AddFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("AddFiles found %d marked fields, want 3", n)
+	}
+	card := message(t, files, "test.Card", "").Descriptor()
+	for field, want := range map[string]bool{"number": true, "cvv": true, "tags": true, "holder": false, "pin": false} {
+		if got := s.Marked(card.Fields().ByName(protoreflect.Name(field))); got != want {
+			t.Errorf("Marked(%s) = %t, want %t", field, got, want)
+		}
+	}
+	wallet := message(t, files, "test.Wallet", "").Descriptor()
+	if !s.Covers(wallet) {
+		t.Error("Covers(Wallet) = false, want true as it holds cards")
+	}
+
+	// the option is found by name, whatever its number
+	other := NewSensitive("privacy.secret", Last4)
+	if other.Covers(wallet) {
+		t.Error("an option no file declares marks fields")
+	}
+	var none *Sensitive
+	if none.Marked(card.Fields().ByName("number")) || none.Covers(wallet) {
+		t.Error("a nil Sensitive marks fields")
+	}
+}
+
+func TestSensitiveBadOption(t *testing.T) {
+	// sensitive declared as a string rather than a bool
+	files := testFiles(t, `
+name: "privacy/privacy.proto"
+package: "privacy"
+syntax: "proto3"
+dependency: "google/protobuf/descriptor.proto"
+extension { name: "sensitive" number: 50000 label: LABEL_OPTIONAL type: TYPE_STRING extendee: ".google.protobuf.FieldOptions" json_name: "sensitive" }
+`)
+	if _, err := NewSensitive("privacy.sensitive", Last4).AddFiles(files); err == nil {
+		t.Error("AddFiles accepted a string option")
+	}
+}
+
+func TestSensitiveRedact(t *testing.T) {
+	files := testFiles(t, privacyProto)
+	s := NewSensitive("privacy.sensitive", Last4)
+	m := message(t, files, "test.Wallet", `
+card { number: "4111111111111111" holder: "alice" cvv: 123 }
+cards { number: "5500000000000004" tags: "gold" tags: "business" }
+cards { holder: "bob" }
+`)
+	sum := Summary{}
+	s.Redact(m, sum)
+
+	want := message(t, files, "test.Wallet", `
+card { number: "************1111" holder: "alice" }
+cards { number: "************0004" tags: "****" tags: "****ness" }
+cards { holder: "bob" }
+`)
+	if got, w := prototext.Format(m), prototext.Format(want); got != w {
+		t.Errorf("Redact = %s, want %s", got, w)
+	}
+	wantSum := Summary{
+		"card.number":     {Last4, 1},
+		"card.cvv":        {Clear, 1},
+		"cards[*].number": {Last4, 1},
+		"cards[*].tags":   {Last4, 2},
+	}
+	if len(sum) != len(wantSum) {
+		t.Errorf("Summary = %v, want %v", sum, wantSum)
+	}
+	for p, w := range wantSum {
+		if sum[p] != w {
+			t.Errorf("Summary[%s] = %v, want %v", p, sum[p], w)
+		}
+	}
+
+	// Format leaves the message alone
+	m = message(t, files, "test.Card", `number: "4111111111111111"`)
+	want = message(t, files, "test.Card", `number: "************1111"`)
+	if got, w := s.Format(m), (prototext.MarshalOptions{}).Format(want); got != w {
+		t.Errorf("Format = %q, want %q", got, w)
+	}
+	if got := m.Get(m.Descriptor().Fields().ByName("number")).String(); got != "4111111111111111" {
+		t.Errorf("Format changed the message: number = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package redact
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/fieldpath"
+)
+
+// Sensitive redacts the fields a schema marks with a boolean field option,
+// eg
+//
+//	import "privacy/privacy.proto";
+//
+//	message Card {
+//	  string number = 1 [(privacy.sensitive) = true];
+//	}
+//
+// The option is found by name among the files a message's file imports, so
+// the filter needs no generated code for it; its field number is whatever
+// the schema says.  A nil *Sensitive marks nothing.
+type Sensitive struct {
+	option   protoreflect.FullName
+	strategy Strategy
+
+	mu sync.Mutex
+	// files caches the marked fields of each file scanned, by file path.
+	files map[string]map[protoreflect.FullName]bool
+}
+
+// NewSensitive returns a Sensitive for the named option, masking with
+// strategy.  Fields the strategy cannot mask, eg last4 on a number, are
+// cleared instead.
+func NewSensitive(option protoreflect.FullName, strategy Strategy) *Sensitive {
+	return &Sensitive{option: option, strategy: strategy, files: map[string]map[protoreflect.FullName]bool{}}
+}
+
+// AddFiles scans every file in files up front, so a misdeclared option is
+// reported when the descriptors load.  Files found later, eg through server
+// reflection, are scanned the first time one of their messages is redacted.
+func (s *Sensitive) AddFiles(files *protoregistry.Files) (int, error) {
+	n := 0
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		var marked map[protoreflect.FullName]bool
+		if marked, err = s.file(fd); err != nil {
+			return false
+		}
+		n += len(marked)
+		return true
+	})
+	return n, err
+}
+
+// Marked reports whether fd carries the option.
+func (s *Sensitive) Marked(fd protoreflect.FieldDescriptor) bool {
+	if s == nil || fd.IsExtension() {
+		return false
+	}
+	marked, err := s.file(fd.ParentFile())
+	return err == nil && marked[fd.FullName()]
+}
+
+// Covers reports whether messages of type md can hold a marked field,
+// directly or in a message nested in them.
+func (s *Sensitive) Covers(md protoreflect.MessageDescriptor) bool {
+	if s == nil {
+		return false
+	}
+	return s.covers(md, map[protoreflect.FullName]bool{})
+}
+
+func (s *Sensitive) covers(md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) bool {
+	if seen[md.FullName()] {
+		return false
+	}
+	seen[md.FullName()] = true
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		if s.Marked(fd) {
+			return true
+		}
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		if fd.Message() != nil && s.covers(fd.Message(), seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sensitive) file(fd protoreflect.FileDescriptor) (map[protoreflect.FullName]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if marked, ok := s.files[fd.Path()]; ok {
+		return marked, nil
+	}
+	marked := map[protoreflect.FullName]bool{}
+	ext, err := s.extension(fd, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil {
+		var scan func(protoreflect.MessageDescriptors)
+		scan = func(mds protoreflect.MessageDescriptors) {
+			for i := 0; i < mds.Len(); i++ {
+				md := mds.Get(i)
+				for j := 0; j < md.Fields().Len(); j++ {
+					if f := md.Fields().Get(j); hasBoolOption(f, ext.Number()) {
+						marked[f.FullName()] = true
+					}
+				}
+				scan(md.Messages())
+			}
+		}
+		scan(fd.Messages())
+	}
+	s.files[fd.Path()] = marked
+	return marked, nil
+}
+
+// extension finds the option's declaration in fd or the files it imports.
+func (s *Sensitive) extension(fd protoreflect.FileDescriptor, seen map[string]bool) (protoreflect.ExtensionDescriptor, error) {
+	if seen[fd.Path()] {
+		return nil, nil
+	}
+	seen[fd.Path()] = true
+	if xd := fd.Extensions().ByName(s.option.Name()); xd != nil && xd.FullName() == s.option {
+		if xd.ContainingMessage().FullName() != "google.protobuf.FieldOptions" || xd.Kind() != protoreflect.BoolKind || xd.IsList() {
+			return nil, fmt.Errorf("redact: (%s) in %s must be a bool extension of google.protobuf.FieldOptions", s.option, fd.Path())
+		}
+		return xd, nil
+	}
+	for i := 0; i < fd.Imports().Len(); i++ {
+		xd, err := s.extension(fd.Imports().Get(i).FileDescriptor, seen)
+		if xd != nil || err != nil {
+			return xd, err
+		}
+	}
+	return nil, nil
+}
+
+// hasBoolOption reports whether fd's options set extension number n to true.
+// The extension is not linked into this binary, so it is read from the
+// options' unknown fields.
+func hasBoolOption(fd protoreflect.FieldDescriptor, n protoreflect.FieldNumber) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+	b := opts.ProtoReflect().GetUnknown()
+	set := false
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return false
+		}
+		b = b[l:]
+		if num == n && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return false
+			}
+			// the last occurrence wins
+			set = v != 0
+			b = b[l:]
+			continue
+		}
+		l = protowire.ConsumeFieldValue(num, typ, b)
+		if l < 0 {
+			return false
+		}
+		b = b[l:]
+	}
+	return set
+}
+
+// Redact masks every marked field in msg and the messages nested in it, and
+// records what it masked in sum, which may be nil.
+func (s *Sensitive) Redact(msg protoreflect.Message, sum Summary) {
+	if s == nil {
+		return
+	}
+	if sum == nil {
+		sum = Summary{}
+	}
+	s.redact(msg, "", sum)
+}
+
+func (s *Sensitive) redact(msg protoreflect.Message, prefix string, sum Summary) {
+	// fields are collected first, as msg must not change during Range
+	var fds []protoreflect.FieldDescriptor
+	msg.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+	for _, fd := range fds {
+		path := prefix + string(fd.Name())
+		if s.Marked(fd) {
+			if strategy, n := s.mask(msg, fd); n > 0 {
+				sum.Add(path, strategy, n)
+			}
+			continue
+		}
+		if fd.Message() == nil || (fd.IsMap() && fd.MapValue().Message() == nil) {
+			continue
+		}
+		v := msg.Get(fd)
+		switch {
+		case fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				s.redact(l.Get(i).Message(), path+"[*].", sum)
+			}
+		case fd.IsMap():
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				s.redact(mv.Message(), path+"[*].", sum)
+				return true
+			})
+		default:
+			s.redact(v.Message(), path+".", sum)
+		}
+	}
+}
+
+// mask applies the strategy to every value of a marked field and returns the
+// strategy used and how many values there were.
+func (s *Sensitive) mask(msg protoreflect.Message, fd protoreflect.FieldDescriptor) (Strategy, int) {
+	strategy := s.strategy
+	vd := fd
+	if fd.IsMap() {
+		vd = fd.MapValue()
+	}
+	if strategy.Check(vd) != nil {
+		strategy = Clear
+	}
+
+	p := string(fd.Name())
+	if fd.IsList() || fd.IsMap() {
+		if strategy == Clear {
+			msg.Clear(fd)
+			return strategy, 1
+		}
+		p += "[*]"
+	}
+	path, err := fieldpath.Parse(p)
+	if err != nil {
+		return strategy, 0
+	}
+	refs, _ := path.Refs(msg, false)
+	n := 0
+	for _, r := range refs {
+		if strategy.Apply(r) {
+			n++
+		}
+	}
+	return strategy, n
+}
+
+// Format returns msg as text for logging, with marked fields masked.  msg
+// itself is not changed.
+func (s *Sensitive) Format(msg protoreflect.Message) string {
+	m := proto.Clone(msg.Interface())
+	s.Redact(m.ProtoReflect(), nil)
+	return prototext.MarshalOptions{}.Format(m)
+}
@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// env returns the CEL environment for rules on messages of type md:
+//
+//	message     md, the decoded request or response
+//	headers     map(string, string), the request headers
+//	attributes  map(string, dyn), envoy attributes keyed by namespace
+//	metadata    map(string, dyn), envoy dynamic metadata keyed by namespace
+//	request     map(string, dyn), the last request message forwarded
+//	values      map(string, dyn), what store actions have saved
+//
+// A nil md gives the environment for decode conditions, which have no
+// message, request or values.
+func (s *Set) env(md protoreflect.MessageDescriptor) (*cel.Env, error) {
+	var key any = md
+	if md == nil {
+		key = s
+	}
+	if e, ok := s.envs.Load(key); ok {
+		return e.(*cel.Env), nil
+	}
+	opts := []cel.EnvOption{
+		cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("attributes", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.DynType)),
+	}
+	if md != nil {
+		opts = append(opts,
+			cel.TypeDescs(md.ParentFile()),
+			cel.Variable("message", cel.ObjectType(string(md.FullName()))),
+			cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("values", cel.MapType(cel.StringType, cel.DynType)),
+		)
+	}
+	if md == StatusDescriptor {
+		// so the details of a status can be read
+		opts = append(opts, cel.TypeDescs(errdetails.File_google_rpc_error_details_proto))
+	}
+	e, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.envs.Store(key, e)
+	return e, nil
+}
+
+// compile parses and type-checks expr for messages of type md and checks it
+// returns want (or dyn, which is checked when it runs).
+func (s *Set) compile(md protoreflect.MessageDescriptor, expr string, want *cel.Type) (cel.Program, error) {
+	e, err := s.env(md)
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := e.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("cel %q: %w", expr, iss.Err())
+	}
+	if out := ast.OutputType(); !out.IsExactType(want) && !out.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("cel %q returns %s, want %s", expr, out, want)
+	}
+	return e.Program(ast)
+}
+
+// eval runs prg.  msg is nil for decode conditions.
+func eval(prg cel.Program, msg protoreflect.Message, vars *Vars) (any, error) {
+	headers := map[string]string{}
+	attributes := map[string]*structpb.Struct{}
+	metadata := map[string]*structpb.Struct{}
+	if vars != nil {
+		if vars.Headers != nil {
+			headers = vars.Headers
+		}
+		if vars.Attributes != nil {
+			attributes = vars.Attributes
+		}
+		if vars.Metadata != nil {
+			metadata = vars.Metadata
+		}
+	}
+	in := map[string]any{
+		"headers":    headers,
+		"attributes": attributes,
+		"metadata":   metadata,
+	}
+	if msg != nil {
+		request := &structpb.Struct{}
+		values := map[string]*structpb.Value{}
+		if vars != nil && vars.Request != nil {
+			request = vars.Request
+		}
+		if vars != nil && vars.Values != nil {
+			values = vars.Values
+		}
+		in["message"] = msg.Interface()
+		in["request"] = request
+		in["values"] = values
+	}
+	out, _, err := prg.Eval(in)
+	if err != nil {
+		return nil, err
+	}
+	return out.Value(), nil
+}
+
+// celType is the CEL type of a value of fd's kind.
+func celType(fd protoreflect.FieldDescriptor) *cel.Type {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return cel.StringType
+	case protoreflect.BytesKind:
+		return cel.BytesType
+	case protoreflect.BoolKind:
+		return cel.BoolType
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return cel.UintType
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return cel.DoubleType
+	}
+	// signed integers and enums
+	return cel.IntType
+}
+
+// nativeValue converts the result of a CEL expression to a value of fd.
+func nativeValue(fd protoreflect.FieldDescriptor, v any) (protoreflect.Value, error) {
+	var pv protoreflect.Value
+	switch x := v.(type) {
+	case string:
+		if fd.Kind() == protoreflect.StringKind {
+			pv = protoreflect.ValueOfString(x)
+		}
+	case []byte:
+		if fd.Kind() == protoreflect.BytesKind {
+			pv = protoreflect.ValueOfBytes(x)
+		}
+	case bool:
+		if fd.Kind() == protoreflect.BoolKind {
+			pv = protoreflect.ValueOfBool(x)
+		}
+	case int64:
+		switch fd.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+			pv = protoreflect.ValueOfInt32(int32(x))
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			pv = protoreflect.ValueOfInt64(x)
+		case protoreflect.EnumKind:
+			pv = protoreflect.ValueOfEnum(protoreflect.EnumNumber(x))
+		}
+	case uint64:
+		switch fd.Kind() {
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+			pv = protoreflect.ValueOfUint32(uint32(x))
+		case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			pv = protoreflect.ValueOfUint64(x)
+		}
+	case float64:
+		switch fd.Kind() {
+		case protoreflect.FloatKind:
+			pv = protoreflect.ValueOfFloat32(float32(x))
+		case protoreflect.DoubleKind:
+			pv = protoreflect.ValueOfFloat64(x)
+		}
+	}
+	if !pv.IsValid() {
+		return pv, fmt.Errorf("cannot assign %T to %s", v, fd.FullName())
+	}
+	return pv, nil
+}
@@ -0,0 +1,1250 @@
+// Package rules matches decoded gRPC messages against declarative rules and
+// rewrites the fields of those that match.
+//
+// Rules are written in YAML:
+//
+//	rules:
+//	- name: alice-to-bob
+//	  method: /echo.EchoServer/SayHelloUnary
+//	  direction: request
+//	  match:
+//	    field: name
+//	    equals: alice
+//	  actions:
+//	  - set: name
+//	    value: bob
+//
+// A match may instead be a CEL expression, and the value of a set or append
+// may be computed by one:
+//
+//	match:
+//	  cel: message.name == "alice" && headers["x-tenant"] == "acme"
+//	actions:
+//	- set: name
+//	  cel: headers["x-user"]
+//
+// Expressions see the decoded message as message, the request headers as
+// headers, envoy's attributes as attributes and the dynamic metadata envoy
+// forwards as metadata.  They are type-checked against each method the rule
+// matches when the rules are loaded.
+//
+// A deny action ends the call with a gRPC error instead of forwarding the
+// message; field violations are sent to the client as google.rpc.BadRequest
+// details:
+//
+//	actions:
+//	- deny:
+//	    code: INVALID_ARGUMENT
+//	    message: mallory is not allowed
+//	    field_violations:
+//	    - field: name
+//	      description: must not be mallory
+//
+// Actions can also set or remove headers, eg to route on a field of the
+// request:
+//
+//	actions:
+//	- set_header: x-tenant-id
+//	  from: tenant_id
+//	- remove_header: x-debug
+//
+// The value of a set or append can also be copied from a request header or
+// an envoy attribute, eg to pass the caller's identity to the backend.  With
+// mode if_empty a set only fills a field that is not already set:
+//
+//	actions:
+//	- set: caller.id
+//	  from_header: x-user-id
+//	- set: caller.address
+//	  from_attribute: source.address
+//	  mode: if_empty
+//
+// A metadata action sends a field to envoy as dynamic metadata, where access
+// logs, RBAC and later filters can read it.  Messages are sent as a Struct:
+//
+//	actions:
+//	- metadata: tenant
+//	  from: tenant_id
+//
+// Rules on responses and statuses can refer to the request.  In CEL, request
+// is the last request message forwarded, as a map keyed by proto field name,
+// and values holds what store actions have saved earlier in the call:
+//
+//	rules:
+//	- name: remember-name
+//	  method: /echo.EchoServer/SayHelloUnary
+//	  actions:
+//	  - store: requested
+//	    from: name
+//	- name: reply-mentions-name
+//	  method: /echo.EchoServer/SayHelloUnary
+//	  direction: response
+//	  match:
+//	    cel: '!message.message.contains(values.requested)'
+//	  actions:
+//	  - append: message
+//	    from_value: requested
+//
+// Decoding itself can be made conditional, so that envoy only buffers and
+// sends the bodies of calls the rules care about.  If any decode entry names
+// a call's method, its bodies are only decoded if one of their conditions
+// holds when the request headers arrive:
+//
+//	decode:
+//	- method: /echo.EchoServer/SayHelloUnary
+//	  when: '"alice" in metadata["envoy.filters.http.grpc_field_extraction"]["name"]'
+//
+// Envoy only sends the filter the bodies of methods that some rule names.
+// How it sends them can be chosen per method; the first entry that matches
+// wins:
+//
+//	processing:
+//	- method: /echo.EchoServer/SayHelloBiDiStream
+//	  request_body: streamed
+//	  response_body: none
+//
+// A rule without a match applies to every message of its method and
+// direction.  Rules run in the order they are listed and each one sees the
+// changes made by those before it.
+package rules
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/fieldpath"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/redact"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
+)
+
+// Direction is the half of the call a rule applies to, or Status for the
+// status it ends with.
+type Direction int
+
+const (
+	Request Direction = iota
+	Response
+	// Status rules see the call's grpc-status, grpc-message and
+	// grpc-status-details-bin as a google.rpc.Status.
+	Status
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Response:
+		return "response"
+	case Status:
+		return "status"
+	}
+	return "request"
+}
+
+func (d *Direction) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Value {
+	case "request":
+		*d = Request
+	case "response":
+		*d = Response
+	case "status":
+		*d = Status
+	default:
+		return fmt.Errorf("line %d: direction must be request, response or status, got %q", n.Line, n.Value)
+	}
+	return nil
+}
+
+// StatusDescriptor is the message type Status rules apply to.
+var StatusDescriptor = (&spb.Status{}).ProtoReflect().Descriptor()
+
+// File is the contents of a rules file.
+type File struct {
+	Rules      []Rule       `yaml:"rules"`
+	Decode     []Gate       `yaml:"decode"`
+	Processing []Processing `yaml:"processing"`
+}
+
+// Processing picks how envoy sends the calls of one or more methods to the
+// filter.
+type Processing struct {
+	// Method is a gRPC :path and may contain path.Match wildcards.
+	Method string `yaml:"method"`
+	// RequestBody and ResponseBody are buffered, streamed or none.  Empty
+	// means the filter's default.
+	RequestBody  string `yaml:"request_body"`
+	ResponseBody string `yaml:"response_body"`
+	// ResponseHeaders and ResponseTrailers are send or skip.  Empty means
+	// send.
+	ResponseHeaders  string `yaml:"response_headers"`
+	ResponseTrailers string `yaml:"response_trailers"`
+}
+
+// Gate is a condition for decoding the calls of one or more methods.
+type Gate struct {
+	// Method is a gRPC :path and may contain path.Match wildcards.
+	Method string `yaml:"method"`
+	// When is a boolean CEL expression over headers, attributes and
+	// metadata.
+	When string `yaml:"when"`
+}
+
+// Rule rewrites the messages of one or more methods.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Method is a gRPC :path, eg /echo.EchoServer/SayHelloUnary.  It may
+	// contain path.Match wildcards, eg /echo.EchoServer/*.
+	Method    string    `yaml:"method"`
+	Direction Direction `yaml:"direction"`
+	Match     *Match    `yaml:"match"`
+	Actions   []Action  `yaml:"actions"`
+}
+
+// Match is a predicate on one field, or a CEL expression.  Exactly one of
+// Equals, Regex, Prefix, Range and CEL is set.
+type Match struct {
+	Field  string  `yaml:"field"`
+	Equals *string `yaml:"equals"`
+	Regex  string  `yaml:"regex"`
+	Prefix string  `yaml:"prefix"`
+	Range  *Range  `yaml:"range"`
+	// CEL is a boolean expression over message, headers and attributes;
+	// Field is not used.
+	CEL string `yaml:"cel"`
+}
+
+// Range matches a numeric field between Min and Max inclusive; either bound
+// may be left out.
+type Range struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+// Action changes one field or header, or denies the call.  Exactly one of
+// Set, Clear, Append, ReplaceRegex and Redact names the field to change, one
+// of SetHeader and RemoveHeader names the header, or Deny is set.
+type Action struct {
+	// Set assigns Value to a scalar field.
+	Set string `yaml:"set"`
+	// Clear resets a field to its default.
+	Clear string `yaml:"clear"`
+	// Append adds Value to a repeated field, or to the end of a string field.
+	Append string `yaml:"append"`
+	// ReplaceRegex replaces matches of Pattern in a string field with
+	// Replacement, which may refer to submatches as $1.
+	ReplaceRegex string `yaml:"replace_regex"`
+	// Redact masks the field's values with Strategy, and records that it did
+	// in the Result.
+	Redact string `yaml:"redact"`
+	// Deny rejects the message and ends the call with an error; the actions
+	// and rules after it do not run.
+	Deny *Deny `yaml:"deny"`
+	// SetHeader sets a header of the direction's headers to Value, the
+	// value of the field From, or the result of CEL.
+	SetHeader string `yaml:"set_header"`
+	// RemoveHeader removes a header of the direction's headers.
+	RemoveHeader string `yaml:"remove_header"`
+	// Metadata sends the field From to envoy as dynamic metadata under this
+	// key.
+	Metadata string `yaml:"metadata"`
+	// Store saves the field From under this key for the rest of the call,
+	// where later rules can read it as values.<key> or with FromValue.
+	Store string `yaml:"store"`
+	// From is the field SetHeader, Metadata or Store copies.  Repeated
+	// values are joined with commas; if it selects no value the header is
+	// left alone.
+	From string `yaml:"from"`
+
+	Value string `yaml:"value"`
+	// CEL computes the value for Set or Append in place of Value.
+	CEL string `yaml:"cel"`
+	// FromHeader takes the value for Set or Append from a request header.
+	FromHeader string `yaml:"from_header"`
+	// FromAttribute takes the value for Set or Append from an attribute in
+	// envoy's envoy.filters.http.ext_proc namespace, eg source.address.
+	FromAttribute string `yaml:"from_attribute"`
+	// FromValue takes the value for Set or Append from what a store action
+	// saved earlier in the call.
+	FromValue string `yaml:"from_value"`
+	// Mode is overwrite (the default) or if_empty, which only sets fields
+	// that are not set.
+	Mode Mode `yaml:"mode"`
+
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+	// Strategy is last4, hash or clear.
+	Strategy string `yaml:"strategy"`
+}
+
+// Mode is how a set action treats a field that is already set.
+type Mode int
+
+const (
+	Overwrite Mode = iota
+	IfEmpty
+)
+
+func (m *Mode) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Value {
+	case "overwrite":
+		*m = Overwrite
+	case "if_empty":
+		*m = IfEmpty
+	default:
+		return fmt.Errorf("line %d: mode must be overwrite or if_empty, got %q", n.Line, n.Value)
+	}
+	return nil
+}
+
+// AttributeNamespace is the namespace envoy sends the request_attributes of
+// the ext_proc filter in.
+const AttributeNamespace = "envoy.filters.http.ext_proc"
+
+// Deny is the error a denied call ends with.
+type Deny struct {
+	// Code is a gRPC status code name, eg INVALID_ARGUMENT.  It defaults to
+	// PERMISSION_DENIED.
+	Code    string `yaml:"code"`
+	Message string `yaml:"message"`
+	// FieldViolations, if any, are sent as google.rpc.BadRequest details.
+	FieldViolations []FieldViolation `yaml:"field_violations"`
+}
+
+// FieldViolation describes one bad field of a denied message.
+type FieldViolation struct {
+	Field       string `yaml:"field"`
+	Description string `yaml:"description"`
+}
+
+// Result is what applying rules to one message did.
+type Result struct {
+	// Changed is true if an action wrote to the message, so it must be
+	// re-encoded.  Actions that find nothing to change leave it false.
+	Changed bool
+	// Redacted is keyed by the field path of each redact action that masked
+	// something.
+	Redacted redact.Summary
+	// Denied is set if a deny action ran.
+	Denied *Denial
+	// Headers are the header changes made, in order.
+	Headers []HeaderChange
+	// Metadata is the dynamic metadata to send to envoy, by key.
+	Metadata map[string]*structpb.Value
+	// Stored are the values store actions saved, by key.
+	Stored map[string]*structpb.Value
+}
+
+// HeaderChange sets or removes one header.
+type HeaderChange struct {
+	// Name is lower case.
+	Name   string
+	Value  string
+	Remove bool
+}
+
+// Denial is a call denied by a rule.
+type Denial struct {
+	// Rule is the name of the rule that denied the call.
+	Rule   string
+	Status *status.Status
+}
+
+// Vars are the parts of the call other than the message that CEL
+// expressions can refer to.
+type Vars struct {
+	// Headers are the request headers, keyed by lower case name.
+	Headers map[string]string
+	// Attributes are the envoy attributes sent with the ProcessingRequest,
+	// keyed by namespace.
+	Attributes map[string]*structpb.Struct
+	// Metadata is the dynamic metadata envoy forwarded in the
+	// ProcessingRequest's metadata_context, keyed by namespace.
+	Metadata map[string]*structpb.Struct
+	// Request is the last request message forwarded, as returned by
+	// MessageStruct, or nil if there has been none.
+	Request *structpb.Struct
+	// Values are what store actions have saved so far in the call, by key.
+	Values map[string]*structpb.Value
+}
+
+// Parse reads a rules file.
+func Parse(b []byte) (*File, error) {
+	var f File
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("rules: %w", err)
+	}
+	return &f, nil
+}
+
+// Set is a list of compiled rules.  A nil *Set has no rules.
+type Set struct {
+	rules      []*rule
+	gates      []*gate
+	processing []Processing
+	// sensitive fields are masked in the values metadata actions send.
+	sensitive *redact.Sensitive
+	// envs caches the CEL environment for each message type.
+	envs sync.Map
+}
+
+type rule struct {
+	set       *Set
+	name      string
+	method    string
+	direction Direction
+	match     *predicate
+	actions   []*action
+
+	// bound caches the rule resolved against each message type it has seen.
+	bound sync.Map
+}
+
+type predicate struct {
+	field  *fieldpath.Path
+	equals *string
+	regex  *regexp.Regexp
+	prefix string
+	rng    *Range
+	cel    string
+}
+
+type action struct {
+	op            string
+	field         *fieldpath.Path
+	value         string
+	cel           string
+	fromHeader    string
+	fromAttribute string
+	fromValue     string
+	mode          Mode
+	pattern       *regexp.Regexp
+	replacement   string
+	strategy      redact.Strategy
+	// deny is the status a deny action ends the call with.
+	deny *status.Status
+	// header is the name set_header or remove_header change, or the key
+	// metadata or store sets, and from the field set_header, metadata or
+	// store copies.
+	header string
+	from   *fieldpath.Path
+}
+
+// actionFunc runs one bound action.  It reports whether it wrote to msg, so
+// that a message no action changed is forwarded as it arrived.
+type actionFunc func(msg protoreflect.Message, vars *Vars, res *Result) (bool, error)
+
+// bound is a rule resolved against one message type: fields looked up,
+// values converted and expressions compiled.
+type bound struct {
+	match   func(msg protoreflect.Message, vars *Vars) (bool, error)
+	actions []actionFunc
+}
+
+type gate struct {
+	method string
+	when   cel.Program
+}
+
+// NewSet compiles the rules and decode conditions in f.  Every rule is
+// checked against the message types of the methods in registry that it
+// matches; methods found later through reflection are checked as their
+// messages arrive.  Fields sensitive marks are masked in dynamic metadata;
+// sensitive may be nil.
+func NewSet(f *File, registry *schema.Registry, sensitive *redact.Sensitive) (*Set, error) {
+	s := &Set{sensitive: sensitive}
+	for i, p := range f.Processing {
+		if err := checkProcessing(p); err != nil {
+			return nil, fmt.Errorf("rules: processing[%d]: %w", i, err)
+		}
+		s.processing = append(s.processing, p)
+	}
+	for i, g := range f.Decode {
+		if _, err := path.Match(g.Method, ""); err != nil || g.Method == "" {
+			return nil, fmt.Errorf("rules: decode[%d]: method %q is not a valid pattern", i, g.Method)
+		}
+		if g.When == "" {
+			return nil, fmt.Errorf("rules: decode[%d]: when is required", i)
+		}
+		prg, err := s.compile(nil, g.When, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("rules: decode[%d]: %w", i, err)
+		}
+		s.gates = append(s.gates, &gate{method: g.Method, when: prg})
+	}
+	for i, r := range f.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rules[%d]", i)
+		}
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rules: %s: %w", r.Name, err)
+		}
+		c.set = s
+		if err := checkMethod(r.Method, registry); err != nil {
+			return nil, fmt.Errorf("rules: %s: %w", r.Name, err)
+		}
+		for _, md := range registry.Methods() {
+			if !c.matchesMethod(md) {
+				continue
+			}
+			if _, err := c.bind(c.messageType(md)); err != nil {
+				return nil, fmt.Errorf("rules: %s: %s: %w", r.Name, schema.MethodPath(md), err)
+			}
+		}
+		s.rules = append(s.rules, c)
+	}
+	return s, nil
+}
+
+func compile(r Rule) (*rule, error) {
+	if r.Method == "" {
+		return nil, fmt.Errorf("method is required")
+	}
+	if _, err := path.Match(r.Method, ""); err != nil {
+		return nil, fmt.Errorf("method %q: %w", r.Method, err)
+	}
+	if len(r.Actions) == 0 {
+		return nil, fmt.Errorf("no actions")
+	}
+	c := &rule{name: r.Name, method: r.Method, direction: r.Direction}
+
+	if m := r.Match; m != nil {
+		c.match = &predicate{equals: m.Equals, prefix: m.Prefix, rng: m.Range, cel: m.CEL}
+		var err error
+		if m.CEL == "" || m.Field != "" {
+			if c.match.field, err = fieldpath.Parse(m.Field); err != nil {
+				return nil, fmt.Errorf("match: %w", err)
+			}
+		}
+		n := 0
+		if m.Equals != nil {
+			n++
+		}
+		if m.Regex != "" {
+			n++
+			if c.match.regex, err = regexp.Compile(m.Regex); err != nil {
+				return nil, fmt.Errorf("match: %w", err)
+			}
+		}
+		if m.Prefix != "" {
+			n++
+		}
+		if m.Range != nil {
+			n++
+		}
+		if m.CEL != "" {
+			n++
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("match must have exactly one of equals, regex, prefix, range or cel")
+		}
+		if m.CEL != "" && m.Field != "" {
+			return nil, fmt.Errorf("match: field is not used with cel")
+		}
+	}
+
+	for i, a := range r.Actions {
+		ca := &action{value: a.Value, cel: a.CEL, fromHeader: strings.ToLower(a.FromHeader), fromAttribute: a.FromAttribute, fromValue: a.FromValue, mode: a.Mode, replacement: a.Replacement}
+		var field string
+		n := 0
+		for op, f := range map[string]string{"set": a.Set, "clear": a.Clear, "append": a.Append, "replace_regex": a.ReplaceRegex, "redact": a.Redact} {
+			if f != "" {
+				ca.op, field = op, f
+				n++
+			}
+		}
+		for op, h := range map[string]string{"set_header": a.SetHeader, "remove_header": a.RemoveHeader} {
+			if h != "" {
+				ca.op, ca.header = op, strings.ToLower(h)
+				n++
+			}
+		}
+		if a.Metadata != "" {
+			ca.op, ca.header = "metadata", a.Metadata
+			n++
+		}
+		if a.Store != "" {
+			ca.op, ca.header = "store", a.Store
+			n++
+		}
+		if a.Deny != nil {
+			ca.op = "deny"
+			n++
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("actions[%d] must have exactly one of set, clear, append, replace_regex, redact, set_header, remove_header, metadata, store or deny", i)
+		}
+		if a.From != "" && ca.op != "set_header" && ca.op != "metadata" && ca.op != "store" {
+			return nil, fmt.Errorf("actions[%d]: from is only used with set_header, metadata or store", i)
+		}
+		if ca.op == "metadata" || ca.op == "store" {
+			if a.Metadata == "redacted" {
+				return nil, fmt.Errorf("actions[%d]: metadata key redacted is reserved", i)
+			}
+			if a.From == "" {
+				return nil, fmt.Errorf("actions[%d]: %s needs from", i, ca.op)
+			}
+			var err error
+			if ca.from, err = fieldpath.Parse(a.From); err != nil {
+				return nil, fmt.Errorf("actions[%d]: %w", i, err)
+			}
+			c.actions = append(c.actions, ca)
+			continue
+		}
+		if (a.FromHeader != "" || a.FromAttribute != "" || a.FromValue != "") && ca.op != "set" && ca.op != "append" {
+			return nil, fmt.Errorf("actions[%d]: from_header, from_attribute and from_value are only used with set or append", i)
+		}
+		if a.Mode != Overwrite && ca.op != "set" {
+			return nil, fmt.Errorf("actions[%d]: mode is only used with set", i)
+		}
+		var err error
+		if ca.header != "" {
+			if strings.HasPrefix(ca.header, ":") || ca.header == "host" {
+				return nil, fmt.Errorf("actions[%d]: cannot change %s", i, ca.header)
+			}
+			if ca.op == "set_header" {
+				n := 0
+				for _, v := range []string{a.Value, a.From, a.CEL} {
+					if v != "" {
+						n++
+					}
+				}
+				if n != 1 {
+					return nil, fmt.Errorf("actions[%d]: set_header must have exactly one of value, from or cel", i)
+				}
+				if a.From != "" {
+					if ca.from, err = fieldpath.Parse(a.From); err != nil {
+						return nil, fmt.Errorf("actions[%d]: %w", i, err)
+					}
+				}
+			}
+			c.actions = append(c.actions, ca)
+			continue
+		}
+		if ca.op == "deny" {
+			if r.Direction == Status {
+				return nil, fmt.Errorf("actions[%d]: a status cannot be denied", i)
+			}
+			if ca.deny, err = denyStatus(a.Deny); err != nil {
+				return nil, fmt.Errorf("actions[%d]: deny: %w", i, err)
+			}
+			c.actions = append(c.actions, ca)
+			continue
+		}
+		if ca.field, err = fieldpath.Parse(field); err != nil {
+			return nil, fmt.Errorf("actions[%d]: %w", i, err)
+		}
+		if a.CEL != "" && ca.op != "set" && ca.op != "append" {
+			return nil, fmt.Errorf("actions[%d]: cel can only compute the value of set, append or set_header", i)
+		}
+		sources := 0
+		for _, v := range []string{a.Value, a.CEL, a.FromHeader, a.FromAttribute, a.FromValue} {
+			if v != "" {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return nil, fmt.Errorf("actions[%d]: only one of value, cel, from_header, from_attribute and from_value may be given", i)
+		}
+		if ca.op == "replace_regex" {
+			if ca.pattern, err = regexp.Compile(a.Pattern); err != nil {
+				return nil, fmt.Errorf("actions[%d]: %w", i, err)
+			}
+		}
+		if ca.op == "redact" {
+			if ca.strategy, err = redact.ParseStrategy(a.Strategy); err != nil {
+				return nil, fmt.Errorf("actions[%d]: %w", i, err)
+			}
+		}
+		c.actions = append(c.actions, ca)
+	}
+	return c, nil
+}
+
+// denyStatus builds the status a deny action ends the call with.
+func denyStatus(d *Deny) (*status.Status, error) {
+	code := codes.PermissionDenied
+	if d.Code != "" {
+		if err := code.UnmarshalJSON([]byte(`"` + d.Code + `"`)); err != nil {
+			return nil, err
+		}
+		if code == codes.OK {
+			return nil, fmt.Errorf("code must not be OK")
+		}
+	}
+	st := status.New(code, d.Message)
+	if len(d.FieldViolations) == 0 {
+		return st, nil
+	}
+	br := &errdetails.BadRequest{}
+	for _, v := range d.FieldViolations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	return st.WithDetails(br)
+}
+
+// checkMethod rejects a method that is not a pattern and is either not a
+// gRPC :path or missing from a service registry has.  Methods of services
+// registry lacks may still be found through reflection.
+func checkMethod(method string, registry *schema.Registry) error {
+	if strings.ContainsAny(method, `*?[\`) {
+		return nil
+	}
+	service, _, err := schema.ParsePath(method)
+	if err != nil {
+		return err
+	}
+	if _, err := registry.Files().FindDescriptorByName(service); err != nil {
+		return nil
+	}
+	_, err = registry.Method(method)
+	return err
+}
+
+func (r *rule) matchesMethod(md protoreflect.MethodDescriptor) bool {
+	ok, _ := path.Match(r.method, schema.MethodPath(md))
+	return ok
+}
+
+func (r *rule) messageType(md protoreflect.MethodDescriptor) protoreflect.MessageDescriptor {
+	switch r.direction {
+	case Response:
+		return md.Output()
+	case Status:
+		return StatusDescriptor
+	}
+	return md.Input()
+}
+
+// bind resolves the rule against messages of type md.
+func (r *rule) bind(md protoreflect.MessageDescriptor) (*bound, error) {
+	if b, ok := r.bound.Load(md); ok {
+		return b.(*bound), nil
+	}
+	b := &bound{}
+	var err error
+	if r.match != nil {
+		if b.match, err = r.match.bind(md, r.set); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range r.actions {
+		fn, err := a.bind(md, r.set)
+		if err != nil {
+			return nil, err
+		}
+		b.actions = append(b.actions, fn)
+	}
+	r.bound.Store(md, b)
+	return b, nil
+}
+
+func (p *predicate) bind(md protoreflect.MessageDescriptor, s *Set) (func(protoreflect.Message, *Vars) (bool, error), error) {
+	if p.cel != "" {
+		prg, err := s.compile(md, p.cel, cel.BoolType)
+		if err != nil {
+			return nil, err
+		}
+		return func(msg protoreflect.Message, vars *Vars) (bool, error) {
+			v, err := eval(prg, msg, vars)
+			if err != nil {
+				return false, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return false, fmt.Errorf("match: %q returned %T, not bool", p.cel, v)
+			}
+			return b, nil
+		}, nil
+	}
+
+	sel, err := p.field.Lookup(md)
+	if err != nil {
+		return nil, err
+	}
+	if !sel.Singular() {
+		return nil, fmt.Errorf("cannot match on %s: use [*] to match its elements", sel.Field.FullName())
+	}
+	fd := sel.Field
+	var test func(protoreflect.Value) bool
+	switch {
+	case p.equals != nil:
+		want, err := scalarValue(fd, *p.equals)
+		if err != nil {
+			return nil, err
+		}
+		test = want.Equal
+	case p.regex != nil, p.prefix != "":
+		if fd.Kind() != protoreflect.StringKind {
+			return nil, fmt.Errorf("cannot match %s as a string", fd.FullName())
+		}
+		if p.regex != nil {
+			test = func(v protoreflect.Value) bool { return p.regex.MatchString(v.String()) }
+		} else {
+			test = func(v protoreflect.Value) bool { return strings.HasPrefix(v.String(), p.prefix) }
+		}
+	case p.rng != nil:
+		if !isNumeric(fd) {
+			return nil, fmt.Errorf("cannot match %s against a range: not a number", fd.FullName())
+		}
+		test = func(v protoreflect.Value) bool {
+			n := toFloat(fd, v)
+			return (p.rng.Min == nil || n >= *p.rng.Min) && (p.rng.Max == nil || n <= *p.rng.Max)
+		}
+	}
+	// a path with [*] matches if any of the values it selects does
+	return func(msg protoreflect.Message, _ *Vars) (bool, error) {
+		vs, err := p.field.Values(msg)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range vs {
+			if test(v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+func (a *action) bind(md protoreflect.MessageDescriptor, s *Set) (actionFunc, error) {
+	if a.op == "deny" {
+		return func(_ protoreflect.Message, _ *Vars, res *Result) (bool, error) {
+			res.Denied = &Denial{Status: a.deny}
+			return false, nil
+		}, nil
+	}
+	if a.op == "set_header" || a.op == "remove_header" {
+		return a.bindHeader(md, s)
+	}
+	if a.op == "metadata" || a.op == "store" {
+		return a.bindMetadata(md, s)
+	}
+	sel, err := a.field.Lookup(md)
+	if err != nil {
+		return nil, err
+	}
+	fd := sel.Field
+	// list is true when append adds an element rather than text.
+	list := !sel.Singular() && fd.IsList()
+
+	// value returns what set or append should write, or an invalid Value if
+	// the header, attribute or stored value it comes from is missing.
+	var value func(protoreflect.Message, *Vars) (protoreflect.Value, error)
+	if a.op == "set" || a.op == "append" {
+		if !sel.Singular() && !(a.op == "append" && list) {
+			return nil, fmt.Errorf("cannot %s %s: not a singular field", a.op, fd.FullName())
+		}
+		if a.op == "append" && !list && fd.Kind() != protoreflect.StringKind {
+			return nil, fmt.Errorf("cannot append to %s: not a repeated or string field", fd.FullName())
+		}
+		switch {
+		case a.cel != "":
+			if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+				return nil, fmt.Errorf("cannot %s %s: only scalar fields can be computed", a.op, fd.FullName())
+			}
+			prg, err := s.compile(md, a.cel, celType(fd))
+			if err != nil {
+				return nil, err
+			}
+			value = func(msg protoreflect.Message, vars *Vars) (protoreflect.Value, error) {
+				v, err := eval(prg, msg, vars)
+				if err != nil {
+					return protoreflect.Value{}, err
+				}
+				return nativeValue(fd, v)
+			}
+		case a.fromHeader != "" || a.fromAttribute != "" || a.fromValue != "":
+			if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+				return nil, fmt.Errorf("cannot %s %s: only scalar fields can be copied", a.op, fd.FullName())
+			}
+			value = func(_ protoreflect.Message, vars *Vars) (protoreflect.Value, error) {
+				s, ok := a.source(vars)
+				if !ok {
+					return protoreflect.Value{}, nil
+				}
+				return scalarValue(fd, s)
+			}
+		default:
+			v, err := scalarValue(fd, a.value)
+			if err != nil {
+				return nil, err
+			}
+			value = func(protoreflect.Message, *Vars) (protoreflect.Value, error) { return v, nil }
+		}
+	}
+
+	switch a.op {
+	case "clear":
+		return func(msg protoreflect.Message, _ *Vars, _ *Result) (bool, error) {
+			refs, err := a.field.Refs(msg, false)
+			changed := false
+			for _, r := range refs {
+				if r.Has() {
+					r.Clear()
+					changed = true
+				}
+			}
+			return changed, err
+		}, nil
+	case "set":
+		return func(msg protoreflect.Message, vars *Vars, _ *Result) (bool, error) {
+			v, err := value(msg, vars)
+			if err != nil || !v.IsValid() {
+				return false, err
+			}
+			refs, err := a.field.Refs(msg, true)
+			changed := false
+			for _, r := range refs {
+				if r.Has() && (a.mode == IfEmpty || r.Get().Equal(v)) {
+					continue
+				}
+				r.Set(v)
+				changed = true
+			}
+			return changed, err
+		}, nil
+	case "append":
+		return func(msg protoreflect.Message, vars *Vars, _ *Result) (bool, error) {
+			v, err := value(msg, vars)
+			if err != nil || !v.IsValid() {
+				return false, err
+			}
+			refs, err := a.field.Refs(msg, true)
+			changed := false
+			for _, r := range refs {
+				switch {
+				case list:
+					r.Append(v)
+				case v.String() == "":
+					continue
+				default:
+					r.Set(protoreflect.ValueOfString(r.Get().String() + v.String()))
+				}
+				changed = true
+			}
+			return changed, err
+		}, nil
+	case "redact":
+		if !sel.Singular() {
+			return nil, fmt.Errorf("cannot redact %s: use [*] to redact its elements", fd.FullName())
+		}
+		if err := a.strategy.Check(fd); err != nil {
+			return nil, err
+		}
+		return func(msg protoreflect.Message, _ *Vars, res *Result) (bool, error) {
+			refs, err := a.field.Refs(msg, false)
+			n := 0
+			for _, r := range refs {
+				if a.strategy.Apply(r) {
+					n++
+				}
+			}
+			if n > 0 {
+				res.Redacted.Add(a.field.String(), a.strategy, n)
+			}
+			return n > 0, err
+		}, nil
+	default: // replace_regex
+		if !sel.Singular() || fd.Kind() != protoreflect.StringKind {
+			return nil, fmt.Errorf("cannot replace_regex in %s: not a string field", fd.FullName())
+		}
+		return func(msg protoreflect.Message, _ *Vars, _ *Result) (bool, error) {
+			refs, err := a.field.Refs(msg, false)
+			changed := false
+			for _, r := range refs {
+				if !r.Has() {
+					continue
+				}
+				old := r.Get().String()
+				if s := a.pattern.ReplaceAllString(old, a.replacement); s != old {
+					r.Set(protoreflect.ValueOfString(s))
+					changed = true
+				}
+			}
+			return changed, err
+		}, nil
+	}
+}
+
+func (a *action) bindMetadata(md protoreflect.MessageDescriptor, s *Set) (actionFunc, error) {
+	sel, err := a.from.Lookup(md)
+	if err != nil {
+		return nil, err
+	}
+	return func(msg protoreflect.Message, _ *Vars, res *Result) (bool, error) {
+		// metadata ends up in logs, so sensitive fields are masked even in
+		// requests; stored values stay in the filter
+		if a.op == "metadata" && s.sensitive != nil {
+			msg = proto.Clone(msg.Interface()).ProtoReflect()
+			s.sensitive.Redact(msg, nil)
+		}
+		vs, err := a.from.Values(msg)
+		if err != nil {
+			return false, err
+		}
+		var v *structpb.Value
+		switch {
+		case a.from.Wildcard():
+			l := &structpb.ListValue{}
+			for _, pv := range vs {
+				sv, err := structValue(sel.Field, pv, false)
+				if err != nil {
+					return false, err
+				}
+				l.Values = append(l.Values, sv)
+			}
+			v = structpb.NewListValue(l)
+		case len(vs) == 0:
+			return false, nil
+		default:
+			if v, err = structValue(sel.Field, vs[0], !sel.Singular()); err != nil {
+				return false, err
+			}
+		}
+		if a.op == "store" {
+			if res.Stored == nil {
+				res.Stored = map[string]*structpb.Value{}
+			}
+			res.Stored[a.header] = v
+			return false, nil
+		}
+		if res.Metadata == nil {
+			res.Metadata = map[string]*structpb.Value{}
+		}
+		res.Metadata[a.header] = v
+		return false, nil
+	}, nil
+}
+
+// source returns the header, attribute or stored value the action copies,
+// and false if the call does not have it.
+func (a *action) source(vars *Vars) (string, bool) {
+	if vars == nil {
+		return "", false
+	}
+	if a.fromHeader != "" {
+		v, ok := vars.Headers[a.fromHeader]
+		return v, ok
+	}
+	var v *structpb.Value
+	var ok bool
+	if a.fromValue != "" {
+		v, ok = vars.Values[a.fromValue]
+	} else {
+		v, ok = vars.Attributes[AttributeNamespace].GetFields()[a.fromAttribute]
+	}
+	if !ok {
+		return "", false
+	}
+	switch k := v.GetKind().(type) {
+	case *structpb.Value_StringValue:
+		return k.StringValue, true
+	case *structpb.Value_NumberValue:
+		return strconv.FormatFloat(k.NumberValue, 'f', -1, 64), true
+	case *structpb.Value_BoolValue:
+		return strconv.FormatBool(k.BoolValue), true
+	}
+	return "", false
+}
+
+func (a *action) bindHeader(md protoreflect.MessageDescriptor, s *Set) (actionFunc, error) {
+	if a.op == "remove_header" {
+		return func(_ protoreflect.Message, _ *Vars, res *Result) (bool, error) {
+			res.Headers = append(res.Headers, HeaderChange{Name: a.header, Remove: true})
+			return false, nil
+		}, nil
+	}
+
+	// value returns the header's value, or false to leave it alone.
+	var value func(protoreflect.Message, *Vars) (string, bool, error)
+	switch {
+	case a.from != nil:
+		sel, err := a.from.Lookup(md)
+		if err != nil {
+			return nil, err
+		}
+		fd := sel.Field
+		if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			return nil, fmt.Errorf("cannot copy %s to a header: not a scalar field", fd.FullName())
+		}
+		if !sel.Singular() {
+			return nil, fmt.Errorf("cannot copy %s to a header: use [*] to copy its elements", fd.FullName())
+		}
+		value = func(msg protoreflect.Message, _ *Vars) (string, bool, error) {
+			vs, err := a.from.Values(msg)
+			if err != nil || len(vs) == 0 {
+				return "", false, err
+			}
+			l := make([]string, len(vs))
+			for i, v := range vs {
+				l[i] = headerString(fd, v)
+			}
+			return strings.Join(l, ","), true, nil
+		}
+	case a.cel != "":
+		prg, err := s.compile(md, a.cel, cel.StringType)
+		if err != nil {
+			return nil, err
+		}
+		value = func(msg protoreflect.Message, vars *Vars) (string, bool, error) {
+			v, err := eval(prg, msg, vars)
+			if err != nil {
+				return "", false, err
+			}
+			str, ok := v.(string)
+			if !ok {
+				return "", false, fmt.Errorf("%q returned %T, not string", a.cel, v)
+			}
+			return str, true, nil
+		}
+	default:
+		value = func(protoreflect.Message, *Vars) (string, bool, error) { return a.value, true, nil }
+	}
+	return func(msg protoreflect.Message, vars *Vars, res *Result) (bool, error) {
+		v, ok, err := value(msg, vars)
+		if ok {
+			res.Headers = append(res.Headers, HeaderChange{Name: a.header, Value: v})
+		}
+		return false, err
+	}, nil
+}
+
+func checkProcessing(p Processing) error {
+	if _, err := path.Match(p.Method, ""); err != nil || p.Method == "" {
+		return fmt.Errorf("method %q is not a valid pattern", p.Method)
+	}
+	for _, b := range []string{p.RequestBody, p.ResponseBody} {
+		switch b {
+		case "", "buffered", "streamed", "none":
+		default:
+			return fmt.Errorf("body mode must be buffered, streamed or none, got %q", b)
+		}
+	}
+	for _, h := range []string{p.ResponseHeaders, p.ResponseTrailers} {
+		switch h {
+		case "", "send", "skip":
+		default:
+			return fmt.Errorf("header mode must be send or skip, got %q", h)
+		}
+	}
+	// envoy is told how to send the response body when it sends the headers
+	if p.ResponseHeaders == "skip" && p.ResponseBody != "none" {
+		return fmt.Errorf("response_body needs response_headers: send")
+	}
+	return nil
+}
+
+// Processing returns the first processing entry for method, a gRPC :path.
+func (s *Set) Processing(method string) (Processing, bool) {
+	if s == nil {
+		return Processing{}, false
+	}
+	for _, p := range s.processing {
+		if ok, _ := path.Match(p.Method, method); ok {
+			return p, true
+		}
+	}
+	return Processing{}, false
+}
+
+// Covers reports whether any rule applies to method, a gRPC :path.
+func (s *Set) Covers(method string) bool {
+	if s == nil {
+		return false
+	}
+	for _, r := range s.rules {
+		if ok, _ := path.Match(r.method, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode reports whether the bodies of a call to method, a gRPC :path, should
+// be decoded, given what is known when its request headers arrive.  It is
+// true if no decode condition names the method, or if one of those that do
+// holds.  A condition that fails to evaluate counts as holding.
+func (s *Set) Decode(method string, vars *Vars) bool {
+	if s == nil {
+		return true
+	}
+	named := false
+	for i, g := range s.gates {
+		if ok, _ := path.Match(g.method, method); !ok {
+			continue
+		}
+		named = true
+		v, err := eval(g.when, nil, vars)
+		if err != nil {
+			log.Printf("decode[%d]: %v", i, err)
+			return true
+		}
+		if b, _ := v.(bool); b {
+			return true
+		}
+	}
+	return !named
+}
+
+// Apply runs the rules for method and dir over msg and reports what they
+// did.  Rules that do not fit msg's type, or whose expressions
+// fail to evaluate, are logged and skipped.  Nothing runs after a deny.
+func (s *Set) Apply(method protoreflect.MethodDescriptor, dir Direction, msg protoreflect.Message, vars *Vars) *Result {
+	res := &Result{Redacted: redact.Summary{}}
+	if s == nil {
+		return res
+	}
+	for _, r := range s.rules {
+		if r.direction != dir || !r.matchesMethod(method) {
+			continue
+		}
+		b, err := r.bind(msg.Descriptor())
+		if err != nil {
+			log.Printf("rule %s does not apply to %s: %v", r.name, msg.Descriptor().FullName(), err)
+			continue
+		}
+		if b.match != nil {
+			ok, err := b.match(msg, vars)
+			if err != nil {
+				log.Printf("rule %s: %v", r.name, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		log.Printf("rule %s matched %s %s", r.name, schema.MethodPath(method), dir)
+		for i, a := range b.actions {
+			changed, err := a(msg, vars, res)
+			if changed {
+				res.Changed = true
+			}
+			if err != nil {
+				log.Printf("rule %s: actions[%d]: %v", r.name, i, err)
+				continue
+			}
+			if res.Denied != nil {
+				res.Denied.Rule = r.name
+				return res
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,249 @@
+package rules
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
+)
+
+const unary = "/echo.EchoServer/SayHelloUnary"
+
+func echoRegistry(t *testing.T) *schema.Registry {
+	t.Helper()
+	b, err := os.ReadFile("../../grpc_server/echo/echo.proto.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	r, err := schema.NewRegistry(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func newSet(t *testing.T, y string) (*Set, error) {
+	t.Helper()
+	f, err := Parse([]byte(y))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSet(f, echoRegistry(t), nil)
+}
+
+func TestNewSetErrors(t *testing.T) {
+	tests := []struct {
+		name, rules, want string
+	}{
+		{"no method", `
+rules:
+- actions:
+  - clear: name
+`, "method is required"},
+		{"unknown method", `
+rules:
+- method: /echo.EchoServer/SayGoodbye
+  actions:
+  - clear: name
+`, "unknown method"},
+		{"malformed method", `
+rules:
+- method: echo.EchoServer.SayHelloUnary
+  actions:
+  - clear: name
+`, "malformed gRPC path"},
+		{"unknown field", `
+rules:
+- method: /echo.EchoServer/*
+  actions:
+  - set: nickname
+    value: bob
+`, "nickname"},
+		{"unknown match field", `
+rules:
+- method: /echo.EchoServer/*
+  match:
+    field: nickname
+    equals: bob
+  actions:
+  - clear: name
+`, "nickname"},
+		{"match cel not bool", `
+rules:
+- method: /echo.EchoServer/*
+  match:
+    cel: message.name
+  actions:
+  - clear: name
+`, "bool"},
+		{"set cel wrong type", `
+rules:
+- method: /echo.EchoServer/*
+  actions:
+  - set: name
+    cel: 1 + 1
+`, "string"},
+		{"bad strategy", `
+rules:
+- method: /echo.EchoServer/*
+  actions:
+  - redact: name
+    strategy: blur
+`, "unknown strategy"},
+		{"deny with OK", `
+rules:
+- method: /echo.EchoServer/*
+  actions:
+  - deny:
+      code: OK
+`, "must not be OK"},
+	}
+	for _, tc := range tests {
+		_, err := newSet(t, tc.rules)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: NewSet = %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestNewSetUnknownService(t *testing.T) {
+	// methods of services the descriptors lack may be found through
+	// reflection later
+	if _, err := newSet(t, `
+rules:
+- method: /other.Service/Method
+  actions:
+  - clear: name
+`); err != nil {
+		t.Errorf("NewSet = %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, rules, in, want string
+		changed               bool
+		denied                codes.Code
+	}{
+		{"set", `
+- set: name
+  value: bob`, "alice", "bob", true, codes.OK},
+		{"set to same value", `
+- set: name
+  value: alice`, "alice", "alice", false, codes.OK},
+		{"set if empty", `
+- set: name
+  value: bob
+  mode: if_empty`, "alice", "alice", false, codes.OK},
+		{"set if empty on unset", `
+- set: name
+  value: bob
+  mode: if_empty`, "", "bob", true, codes.OK},
+		{"clear", `
+- clear: name`, "alice", "", true, codes.OK},
+		{"clear unset", `
+- clear: name`, "", "", false, codes.OK},
+		{"append", `
+- append: name
+  value: "!"`, "alice", "alice!", true, codes.OK},
+		{"append nothing", `
+- append: name
+  value: ""`, "alice", "alice", false, codes.OK},
+		{"replace_regex", `
+- replace_regex: name
+  pattern: "l+"
+  replacement: L`, "alice", "aLice", true, codes.OK},
+		{"replace_regex no match", `
+- replace_regex: name
+  pattern: "z+"
+  replacement: Z`, "alice", "alice", false, codes.OK},
+		{"redact last4", `
+- redact: name
+  strategy: last4`, "4111111111111111", "************1111", true, codes.OK},
+		{"redact clear", `
+- redact: name
+  strategy: clear`, "alice", "", true, codes.OK},
+		{"redact unset", `
+- redact: name
+  strategy: last4`, "", "", false, codes.OK},
+		{"deny", `
+- deny:
+    code: INVALID_ARGUMENT
+    message: no`, "alice", "alice", false, codes.InvalidArgument},
+		{"nothing after deny", `
+- deny: {}
+- set: name
+  value: bob`, "alice", "alice", false, codes.PermissionDenied},
+	}
+	reg := echoRegistry(t)
+	md, err := reg.Method(unary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		s, err := newSet(t, "rules:\n- method: "+unary+"\n  actions:"+strings.ReplaceAll(tc.rules, "\n", "\n  "))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		msg := dynamicpb.NewMessage(md.Input())
+		name := md.Input().Fields().ByName("name")
+		if tc.in != "" {
+			msg.Set(name, protoreflect.ValueOfString(tc.in))
+		}
+		res := s.Apply(md, Request, msg, &Vars{})
+		if got := msg.Get(name).String(); got != tc.want {
+			t.Errorf("%s: name = %q, want %q", tc.name, got, tc.want)
+		}
+		if res.Changed != tc.changed {
+			t.Errorf("%s: Changed = %t, want %t", tc.name, res.Changed, tc.changed)
+		}
+		var denied codes.Code
+		if res.Denied != nil {
+			denied = res.Denied.Status.Code()
+		}
+		if denied != tc.denied {
+			t.Errorf("%s: denied with %v, want %v", tc.name, denied, tc.denied)
+		}
+	}
+}
+
+func TestApplyRedactSummary(t *testing.T) {
+	s, err := newSet(t, `
+rules:
+- method: /echo.EchoServer/*
+  actions:
+  - redact: name
+    strategy: hash
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := echoRegistry(t).Method(unary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(md.Input())
+	name := md.Input().Fields().ByName("name")
+	msg.Set(name, protoreflect.ValueOfString("alice"))
+	res := s.Apply(md, Request, msg, &Vars{})
+	if got := msg.Get(name).String(); len(got) != 64 || got == "alice" {
+		t.Errorf("name = %q, want a hex SHA-256", got)
+	}
+	if m := res.Redacted["name"]; m.Count != 1 || m.Strategy != "hash" {
+		t.Errorf("Redacted = %v, want name masked once with hash", res.Redacted)
+	}
+	if !res.Changed {
+		t.Error("Changed = false, want true")
+	}
+}
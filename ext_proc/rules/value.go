@@ -0,0 +1,171 @@
+package rules
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// scalarValue converts a value written in a rules file to the type of fd.
+// Enums may be given by name or number, as may the code of a status.
+func scalarValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var v protoreflect.Value
+	var err error
+	if fd.FullName() == "google.rpc.Status.code" {
+		var c codes.Code
+		if c.UnmarshalJSON([]byte(strconv.Quote(s))) == nil {
+			return protoreflect.ValueOfInt32(int32(c)), nil
+		}
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(s)
+	case protoreflect.BytesKind:
+		v = protoreflect.ValueOfBytes([]byte(s))
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 32)
+		v = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 64)
+		v = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 32)
+		v = protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 64)
+		v = protoreflect.ValueOfUint64(n)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		v = protoreflect.ValueOfFloat64(f)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 32)
+		v = protoreflect.ValueOfEnum(protoreflect.EnumNumber(n))
+	default:
+		return protoreflect.Value{}, fmt.Errorf("%s is a %s, only scalar fields can be compared or set", fd.FullName(), fd.Kind())
+	}
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("%q is not a valid %s for %s", s, fd.Kind(), fd.FullName())
+	}
+	return v, nil
+}
+
+func isNumeric(fd protoreflect.FieldDescriptor) bool {
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.StringKind, protoreflect.BytesKind,
+		protoreflect.EnumKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+// toFloat returns a numeric field value as a float64 for range checks.
+func toFloat(fd protoreflect.FieldDescriptor, v protoreflect.Value) float64 {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+// headerString formats a scalar field value for a header.  Enums are written
+// by name and bytes as base64.
+func headerString(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// structValue converts a value of fd to a Struct value for dynamic metadata.
+// whole is set when v is the entire list or map rather than one element of
+// it.  Messages are converted as they are in JSON, with proto field names.
+func structValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, whole bool) (*structpb.Value, error) {
+	switch {
+	case whole && fd.IsList():
+		l := &structpb.ListValue{}
+		for i := 0; i < v.List().Len(); i++ {
+			sv, err := structValue(fd, v.List().Get(i), false)
+			if err != nil {
+				return nil, err
+			}
+			l.Values = append(l.Values, sv)
+		}
+		return structpb.NewListValue(l), nil
+	case whole && fd.IsMap():
+		st := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		var err error
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			st.Fields[k.String()], err = structValue(fd.MapValue(), mv, false)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStructValue(st), nil
+	}
+
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(v.Message().Interface())
+		if err != nil {
+			return nil, err
+		}
+		sv := &structpb.Value{}
+		if err := protojson.Unmarshal(b, sv); err != nil {
+			return nil, err
+		}
+		return sv, nil
+	case protoreflect.StringKind:
+		return structpb.NewStringValue(v.String()), nil
+	case protoreflect.BoolKind:
+		return structpb.NewBoolValue(v.Bool()), nil
+	case protoreflect.BytesKind, protoreflect.EnumKind:
+		return structpb.NewStringValue(headerString(fd, v)), nil
+	}
+	return structpb.NewNumberValue(toFloat(fd, v)), nil
+}
+
+// MessageStruct converts msg to a Struct keyed by proto field name, the form
+// rules see it in as request.
+func MessageStruct(msg protoreflect.Message) (*structpb.Struct, error) {
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg.Interface())
+	if err != nil {
+		return nil, err
+	}
+	st := &structpb.Struct{}
+	if err := protojson.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// DefaultReflectionTimeout bounds each lookup of a service.
+	DefaultReflectionTimeout = 5 * time.Second
+	// DefaultNegativeTTL is how long a service the server does not know
+	// is remembered as missing.
+	DefaultNegativeTTL = 30 * time.Second
+)
+
+// ReflectionSource resolves methods by asking a server's grpc.reflection.v1
+// service for the files defining them.  Files are fetched once per service
+// and cached for the life of the source.  A service the server does not
+// know, or a server without reflection, is remembered for a while so that
+// every call to it does not cost a round trip.
+type ReflectionSource struct {
+	client      rpb.ServerReflectionClient
+	timeout     time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	services map[protoreflect.FullName]*Registry
+	// missing holds the error a lookup of each unknown service failed with,
+	// until it expires.
+	missing map[protoreflect.FullName]negative
+}
+
+type negative struct {
+	err     error
+	expires time.Time
+}
+
+// ReflectionOpt configures a ReflectionSource.
+type ReflectionOpt func(*ReflectionSource)
+
+// ReflectionTimeoutOpt bounds each lookup of a service to d.  The default is
+// DefaultReflectionTimeout.
+func ReflectionTimeoutOpt(d time.Duration) ReflectionOpt {
+	return func(s *ReflectionSource) { s.timeout = d }
+}
+
+// NegativeTTLOpt sets how long a service the server answered NotFound or
+// Unimplemented for is not asked about again.  The default is
+// DefaultNegativeTTL; 0 turns the negative cache off.
+func NegativeTTLOpt(d time.Duration) ReflectionOpt {
+	return func(s *ReflectionSource) { s.negativeTTL = d }
+}
+
+// NewReflectionSource returns a ReflectionSource using conn.
+func NewReflectionSource(conn grpc.ClientConnInterface, opts ...ReflectionOpt) *ReflectionSource {
+	s := &ReflectionSource{
+		client:      rpb.NewServerReflectionClient(conn),
+		timeout:     DefaultReflectionTimeout,
+		negativeTTL: DefaultNegativeTTL,
+		now:         time.Now,
+		services:    map[protoreflect.FullName]*Registry{},
+		missing:     map[protoreflect.FullName]negative{},
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Method resolves an HTTP/2 :path, fetching its service's descriptors from
+// the server the first time the service is seen.
+func (s *ReflectionSource) Method(ctx context.Context, path string) (protoreflect.MethodDescriptor, error) {
+	service, _, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	r, ok := s.services[service]
+	n, missing := s.missing[service]
+	s.mu.Unlock()
+	if !ok {
+		if missing && s.now().Before(n.expires) {
+			return nil, n.err
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		r, err = s.fetch(ctx, service)
+		if err != nil {
+			if s.negativeTTL > 0 && isMissing(err) {
+				s.mu.Lock()
+				s.missing[service] = negative{err: err, expires: s.now().Add(s.negativeTTL)}
+				s.mu.Unlock()
+			}
+			return nil, err
+		}
+		s.mu.Lock()
+		s.services[service] = r
+		delete(s.missing, service)
+		s.mu.Unlock()
+	}
+	return r.Method(path)
+}
+
+// isMissing reports whether err says the server does not know the service,
+// or does not serve reflection at all.
+func isMissing(err error) bool {
+	var re *reflectionError
+	if errors.As(err, &re) {
+		return re.code == codes.NotFound
+	}
+	c := status.Code(err)
+	return c == codes.NotFound || c == codes.Unimplemented
+}
+
+// reflectionError is an error_response from the server.
+type reflectionError struct {
+	service protoreflect.FullName
+	code    codes.Code
+	message string
+}
+
+func (e *reflectionError) Error() string {
+	return fmt.Sprintf("schema: reflection for %s: %s", e.service, e.message)
+}
+
+// fetch downloads the file defining service and every file it imports that
+// the server did not already include in its answer.
+func (s *ReflectionSource) fetch(ctx context.Context, service protoreflect.FullName) (*Registry, error) {
+	stream, err := s.client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("schema: reflection for %s: %w", service, err)
+	}
+	defer stream.CloseSend()
+
+	set := &descriptorpb.FileDescriptorSet{}
+	have := map[string]bool{}
+	add := func(resp *rpb.ServerReflectionResponse) ([]string, error) {
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, &reflectionError{service: service, code: codes.Code(e.GetErrorCode()), message: e.GetErrorMessage()}
+		}
+		var deps []string
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fd); err != nil {
+				return nil, fmt.Errorf("schema: reflection for %s: %w", service, err)
+			}
+			if have[fd.GetName()] {
+				continue
+			}
+			have[fd.GetName()] = true
+			set.File = append(set.File, fd)
+			deps = append(deps, fd.GetDependency()...)
+		}
+		return deps, nil
+	}
+	ask := func(req *rpb.ServerReflectionRequest) ([]string, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, fmt.Errorf("schema: reflection for %s: %w", service, err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("schema: reflection for %s: %w", service, err)
+		}
+		return add(resp)
+	}
+
+	pending, err := ask(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: string(service),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if have[name] {
+			continue
+		}
+		if _, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+			continue
+		}
+		deps, err := ask(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
+				FileByFilename: name,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, deps...)
+	}
+	return NewRegistry(set)
+}
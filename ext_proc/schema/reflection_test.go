@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fakeReflection serves the echo descriptors, answers NotFound for every
+// other service and never answers for slow.Service.
+type fakeReflection struct {
+	rpb.UnimplementedServerReflectionServer
+	files *descriptorpb.FileDescriptorSet
+	// lookups counts the services asked about.
+	lookups atomic.Int32
+}
+
+func (f *fakeReflection) ServerReflectionInfo(stream rpb.ServerReflection_ServerReflectionInfoServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		resp := &rpb.ServerReflectionResponse{OriginalRequest: req}
+		switch sym := req.GetFileContainingSymbol(); sym {
+		case "echo.EchoServer":
+			f.lookups.Add(1)
+			fdr := &rpb.FileDescriptorResponse{}
+			for _, fd := range f.files.File {
+				b, err := proto.Marshal(fd)
+				if err != nil {
+					return err
+				}
+				fdr.FileDescriptorProto = append(fdr.FileDescriptorProto, b)
+			}
+			resp.MessageResponse = &rpb.ServerReflectionResponse_FileDescriptorResponse{FileDescriptorResponse: fdr}
+		case "slow.Service":
+			f.lookups.Add(1)
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		default:
+			f.lookups.Add(1)
+			resp.MessageResponse = &rpb.ServerReflectionResponse_ErrorResponse{ErrorResponse: &rpb.ErrorResponse{
+				ErrorCode:    int32(codes.NotFound),
+				ErrorMessage: "symbol not found: " + sym,
+			}}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// dial starts a server, with reflection if fake is not nil, and returns a
+// connection to it.
+func dial(t *testing.T, fake *fakeReflection) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	if fake != nil {
+		rpb.RegisterServerReflectionServer(srv, fake)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func echoFiles(t *testing.T) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	b, err := os.ReadFile("../../grpc_server/echo/echo.proto.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestReflectionMethod(t *testing.T) {
+	fake := &fakeReflection{files: echoFiles(t)}
+	s := NewReflectionSource(dial(t, fake))
+	for i := 0; i < 2; i++ {
+		md, err := s.Method(context.Background(), "/echo.EchoServer/SayHelloUnary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := md.Input().FullName(); got != "echo.EchoRequest" {
+			t.Errorf("input = %s, want echo.EchoRequest", got)
+		}
+	}
+	if _, err := s.Method(context.Background(), "/echo.EchoServer/SayGoodbye"); !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("Method(SayGoodbye) = %v, want ErrUnknownMethod", err)
+	}
+	if n := fake.lookups.Load(); n != 1 {
+		t.Errorf("server asked %d times, want once", n)
+	}
+}
+
+func TestReflectionNegativeCache(t *testing.T) {
+	tests := []struct {
+		name string
+		fake *fakeReflection
+	}{
+		{"not found", &fakeReflection{}},
+		// the server does not serve reflection
+		{"unimplemented", nil},
+	}
+	for _, tc := range tests {
+		now := time.Now()
+		s := NewReflectionSource(dial(t, tc.fake), NegativeTTLOpt(time.Minute))
+		s.now = func() time.Time { return now }
+		lookup := func() {
+			t.Helper()
+			if _, err := s.Method(context.Background(), "/other.Service/Method"); err == nil {
+				t.Fatalf("%s: Method succeeded, want an error", tc.name)
+			}
+		}
+		lookup()
+		lookup()
+		if len(s.missing) != 1 {
+			t.Errorf("%s: %d services cached as missing, want 1", tc.name, len(s.missing))
+		}
+		if tc.fake != nil {
+			if n := tc.fake.lookups.Load(); n != 1 {
+				t.Errorf("%s: server asked %d times within the TTL, want once", tc.name, n)
+			}
+		}
+		now = now.Add(2 * time.Minute)
+		lookup()
+		if tc.fake != nil {
+			if n := tc.fake.lookups.Load(); n != 2 {
+				t.Errorf("%s: server asked %d times after the TTL, want twice", tc.name, n)
+			}
+		}
+	}
+}
+
+func TestReflectionTimeout(t *testing.T) {
+	fake := &fakeReflection{}
+	s := NewReflectionSource(dial(t, fake), ReflectionTimeoutOpt(50*time.Millisecond))
+	start := time.Now()
+	_, err := s.Method(context.Background(), "/slow.Service/Method")
+	if err == nil {
+		t.Fatal("Method succeeded, want a timeout")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Method took %v, want it cut off after 50ms", d)
+	}
+	// a timeout says nothing about whether the service exists
+	if len(s.missing) != 0 {
+		t.Errorf("timed out service cached as missing")
+	}
+}
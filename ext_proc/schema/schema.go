@@ -0,0 +1,158 @@
+// Package schema resolves gRPC methods to their request and response message
+// types using FileDescriptorSets, so messages can be decoded with dynamicpb
+// without compiling the service's generated code in.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ErrUnknownMethod is returned when no loaded service defines a method.
+var ErrUnknownMethod = errors.New("schema: unknown method")
+
+// Registry holds the files from one or more FileDescriptorSets.
+type Registry struct {
+	files *protoregistry.Files
+}
+
+// NewRegistry builds a Registry from FileDescriptorSets.  A file may appear
+// in more than one set; imports not found in any set are resolved against
+// the well-known types linked into this binary.
+func NewRegistry(sets ...*descriptorpb.FileDescriptorSet) (*Registry, error) {
+	protos := map[string]*descriptorpb.FileDescriptorProto{}
+	var order []string
+	for _, set := range sets {
+		for _, fd := range set.File {
+			if _, ok := protos[fd.GetName()]; ok {
+				continue
+			}
+			protos[fd.GetName()] = fd
+			order = append(order, fd.GetName())
+		}
+	}
+
+	files := new(protoregistry.Files)
+	resolver := chainResolver{files, protoregistry.GlobalFiles}
+	var add func(name string, seen map[string]bool) error
+	add = func(name string, seen map[string]bool) error {
+		if _, err := files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		fdp, ok := protos[name]
+		if !ok {
+			// left for the resolver to find among the well-known types
+			return nil
+		}
+		if seen[name] {
+			return fmt.Errorf("schema: import cycle through %s", name)
+		}
+		seen[name] = true
+		for _, dep := range fdp.GetDependency() {
+			if err := add(dep, seen); err != nil {
+				return err
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, resolver)
+		if err != nil {
+			return fmt.Errorf("schema: %s: %w", name, err)
+		}
+		return files.RegisterFile(fd)
+	}
+	for _, name := range order {
+		if err := add(name, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return &Registry{files: files}, nil
+}
+
+// Files returns the descriptors held by the registry.
+func (r *Registry) Files() *protoregistry.Files {
+	return r.files
+}
+
+// Method resolves an HTTP/2 :path such as /echo.EchoServer/SayHelloUnary.
+func (r *Registry) Method(path string) (protoreflect.MethodDescriptor, error) {
+	service, method, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := r.files.FindDescriptorByName(service)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, path)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a service", ErrUnknownMethod, service)
+	}
+	md := sd.Methods().ByName(method)
+	if md == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, path)
+	}
+	return md, nil
+}
+
+// Methods returns every method of every service in the registry.
+func (r *Registry) Methods() []protoreflect.MethodDescriptor {
+	var mds []protoreflect.MethodDescriptor
+	r.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			for j := 0; j < sd.Methods().Len(); j++ {
+				mds = append(mds, sd.Methods().Get(j))
+			}
+		}
+		return true
+	})
+	return mds
+}
+
+// MethodPath returns the HTTP/2 :path of md, eg /echo.EchoServer/SayHelloUnary.
+func MethodPath(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// ParsePath splits a gRPC :path into the full service name and method name.
+func ParsePath(path string) (protoreflect.FullName, protoreflect.Name, error) {
+	p := strings.TrimPrefix(path, "/")
+	i := strings.LastIndex(p, "/")
+	if !strings.HasPrefix(path, "/") || i <= 0 || i == len(p)-1 {
+		return "", "", fmt.Errorf("%w: malformed gRPC path %q", ErrUnknownMethod, path)
+	}
+	service, method := protoreflect.FullName(p[:i]), protoreflect.Name(p[i+1:])
+	if !service.IsValid() || !method.IsValid() {
+		return "", "", fmt.Errorf("%w: malformed gRPC path %q", ErrUnknownMethod, path)
+	}
+	return service, method, nil
+}
+
+// chainResolver looks a descriptor up in each resolver in turn.
+type chainResolver []interface {
+	FindFileByPath(string) (protoreflect.FileDescriptor, error)
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+func (c chainResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	for _, r := range c {
+		if fd, err := r.FindFileByPath(path); err == nil {
+			return fd, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (c chainResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, r := range c {
+		if d, err := r.FindDescriptorByName(name); err == nil {
+			return d, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/rules"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+// status applies the status rules to the grpc-status in h, the response
+// trailers or the headers of a trailers-only response.  It returns the
+// changes to make to h, or nil if there are none.
+func (st *stream) status(h *corev3.HeaderMap) *pb.HeaderMutation {
+	md := st.methodDescriptor()
+	if md == nil {
+		return nil
+	}
+	s, ok := statusFromHeaders(h)
+	if !ok {
+		return nil
+	}
+	log.Printf("   status: %s", prototext.MarshalOptions{}.Format(s))
+
+	res := st.config.Rules.Apply(md, rules.Status, s.ProtoReflect(), st.ruleVars())
+	st.addResult(res)
+	var changes []rules.HeaderChange
+	if res.Changed {
+		changes = statusHeaders(s)
+	}
+	// header actions run after the status is written, so they can still
+	// remove any of it
+	return headerMutation(append(changes, res.Headers...))
+}
+
+// statusFromHeaders reads the status of a call from its trailers.  It
+// returns false if there is no grpc-status.  Details are taken from
+// grpc-status-details-bin; the code and message in it are ignored in favour
+// of grpc-status and grpc-message.
+func statusFromHeaders(h *corev3.HeaderMap) (*spb.Status, bool) {
+	s := &spb.Status{}
+	found := false
+	for _, n := range h.GetHeaders() {
+		switch strings.ToLower(n.Key) {
+		case "grpc-status":
+			code, err := strconv.ParseInt(headerValue(n), 10, 32)
+			if err != nil {
+				log.Printf("   ignoring grpc-status %q: %v", headerValue(n), err)
+				return nil, false
+			}
+			s.Code = int32(code)
+			found = true
+		case "grpc-message":
+			s.Message = decodeGrpcMessage(headerValue(n))
+		case "grpc-status-details-bin":
+			b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(headerValue(n), "="))
+			if err != nil {
+				log.Printf("   ignoring grpc-status-details-bin: %v", err)
+				continue
+			}
+			var d spb.Status
+			if err := proto.Unmarshal(b, &d); err != nil {
+				log.Printf("   ignoring grpc-status-details-bin: %v", err)
+				continue
+			}
+			s.Details = d.Details
+		}
+	}
+	return s, found
+}
+
+// statusHeaders returns the header changes that carry s.
+// grpc-status-details-bin is removed if s has no details.
+func statusHeaders(s *spb.Status) []rules.HeaderChange {
+	changes := []rules.HeaderChange{
+		{Name: "grpc-status", Value: strconv.Itoa(int(s.Code))},
+		{Name: "grpc-message", Value: encodeGrpcMessage(s.Message)},
+	}
+	if len(s.Details) == 0 {
+		return append(changes, rules.HeaderChange{Name: "grpc-status-details-bin", Remove: true})
+	}
+	b, err := proto.Marshal(s)
+	if err != nil {
+		log.Printf("   dropping status details: %v", err)
+		return append(changes, rules.HeaderChange{Name: "grpc-status-details-bin", Remove: true})
+	}
+	return append(changes, rules.HeaderChange{Name: "grpc-status-details-bin", Value: base64.RawStdEncoding.EncodeToString(b)})
+}
+
+// encodeGrpcMessage percent-encodes msg for the grpc-message header, as the
+// gRPC HTTP/2 protocol requires.
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// decodeGrpcMessage undoes encodeGrpcMessage.  A % not followed by two hex
+// digits is kept as it is.
+func decodeGrpcMessage(msg string) string {
+	if !strings.Contains(msg, "%") {
+		return msg
+	}
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if n, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
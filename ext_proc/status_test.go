@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGrpcMessageEncoding(t *testing.T) {
+	tests := []struct {
+		msg, encoded string
+	}{
+		{"plain text", "plain text"},
+		{"100%", "100%25"},
+		{"héllo", "h%C3%A9llo"},
+		{"日本", "%E6%97%A5%E6%9C%AC"},
+		{"line\nbreak", "line%0Abreak"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := encodeGrpcMessage(tc.msg); got != tc.encoded {
+			t.Errorf("encodeGrpcMessage(%q) = %q, want %q", tc.msg, got, tc.encoded)
+		}
+		if got := decodeGrpcMessage(tc.encoded); got != tc.msg {
+			t.Errorf("decodeGrpcMessage(%q) = %q, want %q", tc.encoded, got, tc.msg)
+		}
+	}
+}
+
+func TestDecodeMalformedGrpcMessage(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"%zz", "%zz"},
+		{"50%", "50%"},
+		{"%4", "%4"},
+		{"a%zzb%41", "a%zzbA"},
+	}
+	for _, tc := range tests {
+		if got := decodeGrpcMessage(tc.in); got != tc.want {
+			t.Errorf("decodeGrpcMessage(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// trailers returns the header map statusHeaders' changes would leave.
+func trailers(s *spb.Status) *corev3.HeaderMap {
+	h := &corev3.HeaderMap{}
+	for _, c := range statusHeaders(s) {
+		if !c.Remove {
+			h.Headers = append(h.Headers, &corev3.HeaderValue{Key: c.Name, RawValue: []byte(c.Value)})
+		}
+	}
+	return h
+}
+
+func TestStatusHeadersRoundTrip(t *testing.T) {
+	withDetails, err := status.New(codes.InvalidArgument, "bad name: 100% wrong").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "must not be mallory"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []*spb.Status{
+		{Code: int32(codes.OK)},
+		{Code: int32(codes.NotFound), Message: "no such thing: ünïcode"},
+		withDetails.Proto(),
+	}
+	for _, want := range tests {
+		got, ok := statusFromHeaders(trailers(want))
+		if !ok {
+			t.Fatalf("statusFromHeaders(%v) found no status", want)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("round trip of %v = %v", want, got)
+		}
+	}
+}
+
+func TestStatusHeadersDetails(t *testing.T) {
+	changes := statusHeaders(&spb.Status{Code: int32(codes.Internal)})
+	last := changes[len(changes)-1]
+	if last.Name != "grpc-status-details-bin" || !last.Remove {
+		t.Errorf("status without details: last change = %+v, want grpc-status-details-bin removed", last)
+	}
+}
+
+func TestStatusFromHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		h    *corev3.HeaderMap
+		ok   bool
+		want *spb.Status
+	}{
+		{"no status", headers("grpc-message", "x"), false, nil},
+		{"bad status", headers("grpc-status", "x"), false, nil},
+		{"case and encoding", headers("Grpc-Status", "5", "grpc-message", "not%20found"), true,
+			&spb.Status{Code: 5, Message: "not found"}},
+		// details that cannot be read are dropped, the status kept
+		{"bad details", headers("grpc-status", "3", "grpc-status-details-bin", "!!"), true,
+			&spb.Status{Code: 3}},
+	}
+	for _, tc := range tests {
+		got, ok := statusFromHeaders(tc.h)
+		if ok != tc.ok {
+			t.Errorf("%s: found = %t, want %t", tc.name, ok, tc.ok)
+			continue
+		}
+		if ok && !proto.Equal(got, tc.want) {
+			t.Errorf("%s: status = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,845 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/compression"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/config"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/frame"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/metrics"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/redact"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/rules"
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/schema"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// stream is the state of a single Process call.
+//
+// A receive goroutine hands each ProcessingRequest to the pipeline for its
+// direction and every response the pipelines produce is written by one
+// sender.  Neither pipeline waits on the other, so in FULL_DUPLEX_STREAMED
+// mode a message can be sent back as soon as it is complete, whatever is
+// happening on the other half of the call.
+type stream struct {
+	out      chan *pb.ProcessingResponse
+	request  *direction
+	response *direction
+
+	// config is the snapshot that was current when the stream opened.
+	config     *config.Snapshot
+	reflection *schema.ReflectionSource
+
+	mu sync.Mutex
+	// method is resolved from :path in the request headers and read by both
+	// directions.  It is nil if the call's messages are not decoded.
+	method protoreflect.MethodDescriptor
+	// mode is how envoy was asked to send the call.
+	mode *callMode
+	// vars are the request headers, attributes and metadata seen so far,
+	// the last request message and the values store actions have saved,
+	// for rules.
+	vars rules.Vars
+	// redacted accumulates what has been masked in either direction.
+	redacted redact.Summary
+	// fields are the values metadata actions have sent, by key.
+	fields map[string]*structpb.Value
+	// metadataChanged is set when redacted or fields have changed since
+	// dynamic metadata was last sent to envoy.
+	metadataChanged bool
+	// ended is set once an ImmediateResponse has been sent; envoy ends the
+	// call and nothing it sends afterwards needs an answer.
+	ended bool
+
+	// state is shared by the extproc handlers of both directions.
+	state extproc.State
+}
+
+// direction is the pipeline for one half of the HTTP stream.  Its fields are
+// only touched from its own goroutine.
+type direction struct {
+	st *stream
+	in chan *pb.ProcessingRequest
+
+	// grpc-encoding applies to messages travelling in the same direction as
+	// the headers carrying it.
+	encoding string
+	// partial frames are carried across body chunks
+	frames *frame.Reassembler
+	// unframed is set once the body could not be split into messages; the
+	// rest of it is passed through unchanged.
+	unframed bool
+	// headers are the header changes made by rules that have not yet been
+	// sent to envoy.
+	headers []rules.HeaderChange
+	// messageType picks the method's input or output message.
+	messageType func(protoreflect.MethodDescriptor) protoreflect.MessageDescriptor
+	// dir selects the rules that apply to this half of the call.
+	dir rules.Direction
+}
+
+func newStream(snap *config.Snapshot, reflection *schema.ReflectionSource) *stream {
+	st := &stream{
+		out:        make(chan *pb.ProcessingResponse),
+		config:     snap,
+		reflection: reflection,
+	}
+	st.request = st.newDirection(protoreflect.MethodDescriptor.Input, rules.Request)
+	st.response = st.newDirection(protoreflect.MethodDescriptor.Output, rules.Response)
+	return st
+}
+
+func (st *stream) newDirection(messageType func(protoreflect.MethodDescriptor) protoreflect.MessageDescriptor, dir rules.Direction) *direction {
+	return &direction{
+		st:          st,
+		in:          make(chan *pb.ProcessingRequest),
+		frames:      frame.NewReassembler(frame.MaxMessageSizeOpt(uint32(*maxMessageSize))),
+		messageType: messageType,
+		dir:         dir,
+	}
+}
+
+func (st *stream) setMethod(md protoreflect.MethodDescriptor) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.method = md
+}
+
+func (st *stream) setMode(m *callMode) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.mode = m
+}
+
+// callMode returns how envoy was asked to send the call.
+func (st *stream) callMode() *callMode {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.mode == nil {
+		return skipMode
+	}
+	return st.mode
+}
+
+// methodDescriptor returns the method being called, or nil if it is unknown.
+func (st *stream) methodDescriptor() protoreflect.MethodDescriptor {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.method
+}
+
+// setHeaders records the request headers for rules to use.
+func (st *stream) setHeaders(h map[string]string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.vars.Headers = h
+}
+
+// addAttributes records the attributes and forwarded dynamic metadata envoy
+// sent with a request.  Envoy only sends each attribute once, so they are
+// accumulated across the stream; metadata namespaces are replaced by the
+// latest copy.
+func (st *stream) addAttributes(req *pb.ProcessingRequest) {
+	attrs, md := req.Attributes, req.GetMetadataContext().GetFilterMetadata()
+	if len(attrs) == 0 && len(md) == 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.vars.Attributes = mergeNamespaces(st.vars.Attributes, attrs)
+	st.vars.Metadata = mergeNamespaces(st.vars.Metadata, md)
+}
+
+// mergeNamespaces returns a copy of a with the namespaces in b added or
+// replaced, or a itself if b is empty.
+func mergeNamespaces(a, b map[string]*structpb.Struct) map[string]*structpb.Struct {
+	if len(b) == 0 {
+		return a
+	}
+	m := make(map[string]*structpb.Struct, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// changeHeaders applies header changes made by request rules to the headers
+// later rules see.
+func (st *stream) changeHeaders(changes []rules.HeaderChange) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h := make(map[string]string, len(st.vars.Headers)+len(changes))
+	for k, v := range st.vars.Headers {
+		h[k] = v
+	}
+	for _, c := range changes {
+		if c.Remove {
+			delete(h, c.Name)
+		} else {
+			h[c.Name] = c.Value
+		}
+	}
+	st.vars.Headers = h
+}
+
+// ruleVars returns what rules can see of the call so far.  The maps are
+// replaced rather than modified, so the result can be read without the lock.
+func (st *stream) ruleVars() *rules.Vars {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	v := st.vars
+	return &v
+}
+
+// setRequest records the last request message forwarded, for the rules of
+// the rest of the call.
+func (st *stream) setRequest(msg protoreflect.Message) {
+	req, err := rules.MessageStruct(msg)
+	if err != nil {
+		log.Printf("   request not kept for later rules: %v", err)
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.vars.Request = req
+}
+
+// addResult records what the rules did to one message.
+func (st *stream) addResult(res *rules.Result) {
+	if len(res.Redacted) == 0 && len(res.Metadata) == 0 && len(res.Stored) == 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(res.Stored) > 0 {
+		values := make(map[string]*structpb.Value, len(st.vars.Values)+len(res.Stored))
+		for k, v := range st.vars.Values {
+			values[k] = v
+		}
+		for k, v := range res.Stored {
+			values[k] = v
+		}
+		st.vars.Values = values
+	}
+	if len(res.Redacted) == 0 && len(res.Metadata) == 0 {
+		return
+	}
+	if st.fields == nil {
+		st.fields = map[string]*structpb.Value{}
+	}
+	for k, v := range res.Metadata {
+		st.fields[k] = v
+	}
+	if st.redacted == nil {
+		st.redacted = redact.Summary{}
+	}
+	for path, m := range res.Redacted {
+		st.redacted.Add(path, m.Strategy, m.Count)
+	}
+	st.metadataChanged = true
+}
+
+// dynamicMetadata returns the metadata to attach to the next response, or
+// nil if nothing has changed since the last was sent.  Envoy replaces each
+// key of the namespace it is given, so everything is sent each time: the
+// fields sent by metadata actions, and what has been redacted under
+// "redacted".
+func (st *stream) dynamicMetadata() *structpb.Struct {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.metadataChanged {
+		return nil
+	}
+	st.metadataChanged = false
+
+	ns := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	for k, v := range st.fields {
+		ns.Fields[k] = v
+	}
+	if len(st.redacted) > 0 {
+		redacted := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		for path, r := range st.redacted {
+			redacted.Fields[path] = structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+				"strategy": structpb.NewStringValue(string(r.Strategy)),
+				"count":    structpb.NewNumberValue(float64(r.Count)),
+			}})
+		}
+		ns.Fields["redacted"] = structpb.NewStructValue(redacted)
+	}
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		*metadataNamespace: structpb.NewStructValue(ns),
+	}}
+}
+
+// receive reads from envoy until it closes the stream, routing each message
+// to its direction.
+func (st *stream) receive(ctx context.Context, srv pb.ExternalProcessor_ProcessServer) error {
+	defer close(st.request.in)
+	defer close(st.response.in)
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
+		}
+
+		st.addAttributes(req)
+
+		d := st.request
+		switch req.Request.(type) {
+		case *pb.ProcessingRequest_ResponseHeaders, *pb.ProcessingRequest_ResponseBody, *pb.ProcessingRequest_ResponseTrailers:
+			d = st.response
+		}
+		select {
+		case d.in <- req:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// send queues resp for the sender.
+func (st *stream) send(ctx context.Context, resp *pb.ProcessingResponse) error {
+	select {
+	case st.out <- resp:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *direction) run(ctx context.Context) error {
+	for req := range d.in {
+		if d.st.hasEnded() {
+			continue
+		}
+		if err := d.st.handle(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *stream) hasEnded() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.ended
+}
+
+// deny ends the call with the status a rule denied it with.
+func (st *stream) deny(ctx context.Context, d *rules.Denial) error {
+	log.Printf("rule %s denied the call: %v", d.Rule, d.Status.Err())
+	metrics.Denials.WithLabelValues(d.Rule).Inc()
+	return st.immediateResponse(ctx, d.Status, "denied by rule "+d.Rule)
+}
+
+// immediateResponse ends the call with s.  Envoy replies to the client
+// itself, with HTTP 200 and s in grpc-status, grpc-message and, if s has
+// details, grpc-status-details-bin, so the client sees an ordinary gRPC
+// error rather than a reset stream.
+func (st *stream) immediateResponse(ctx context.Context, s *status.Status, details string) error {
+	st.mu.Lock()
+	st.ended = true
+	st.mu.Unlock()
+
+	resp := &pb.ProcessingResponse{
+		Response: &pb.ProcessingResponse_ImmediateResponse{
+			ImmediateResponse: &pb.ImmediateResponse{
+				Status:     &typev3.HttpStatus{Code: typev3.StatusCode_OK},
+				Headers:    headerMutation(statusHeaders(s.Proto())),
+				GrpcStatus: &pb.GrpcStatus{Status: uint32(s.Code())},
+				Details:    details,
+			},
+		},
+	}
+	resp.DynamicMetadata = st.dynamicMetadata()
+	return st.send(ctx, resp)
+}
+
+func (st *stream) handle(ctx context.Context, req *pb.ProcessingRequest) error {
+	switch v := req.Request.(type) {
+	case *pb.ProcessingRequest_RequestHeaders:
+		log.Printf("pb.ProcessingRequest_RequestHeaders %v \n", v)
+		h := v.RequestHeaders
+		log.Printf("Got ProcessingRequest.Attributes %v", req.Attributes)
+		log.Printf("Got RequestHeaders.Headers %v", h.Headers)
+
+		headers := map[string]string{}
+		for _, n := range h.Headers.GetHeaders() {
+			headers[strings.ToLower(n.Key)] = headerValue(n)
+		}
+		st.setHeaders(headers)
+		st.request.encoding = headers["grpc-encoding"]
+		if ae, ok := headers["grpc-accept-encoding"]; ok {
+			log.Printf("Client accepts response encodings %v", compression.ParseAcceptEncoding(ae))
+		}
+
+		m := st.selectMode(ctx, headers)
+		st.setMode(m)
+		if m != skipMode {
+			for _, n := range h.Headers.GetHeaders() {
+				log.Printf("Header %s %s", n.Key, headerValue(n))
+			}
+		}
+		return st.send(ctx, &pb.ProcessingResponse{
+			Response: &pb.ProcessingResponse_RequestHeaders{
+				RequestHeaders: &pb.HeadersResponse{
+					Response: &pb.CommonResponse{},
+				},
+			},
+			ModeOverride: m.override(),
+		})
+
+	case *pb.ProcessingRequest_RequestBody:
+		log.Printf("   RequestBody: %d bytes", len(v.RequestBody.Body))
+		log.Printf("   EndOfStream: %t", v.RequestBody.EndOfStream)
+
+		return st.request.body(ctx, v.RequestBody, requestBodyResponse)
+
+	case *pb.ProcessingRequest_RequestTrailers:
+		log.Printf("pb.ProcessingRequest_RequestTrailers %v \n", v)
+		if pass, err := st.request.endBody(ctx, requestBodyResponse); !pass || err != nil {
+			return err
+		}
+		return st.send(ctx, &pb.ProcessingResponse{
+			Response: &pb.ProcessingResponse_RequestTrailers{
+				RequestTrailers: &pb.TrailersResponse{},
+			},
+		})
+
+	case *pb.ProcessingRequest_ResponseHeaders:
+		log.Printf("pb.ProcessingRequest_ResponseHeaders %v \n", v)
+		for _, n := range v.ResponseHeaders.Headers.GetHeaders() {
+			switch n.Key {
+			case "grpc-encoding":
+				st.response.encoding = headerValue(n)
+			case "grpc-accept-encoding":
+				log.Printf("Server accepts request encodings %v", compression.ParseAcceptEncoding(headerValue(n)))
+			}
+		}
+		resp := &pb.ProcessingResponse{
+			Response: &pb.ProcessingResponse_ResponseHeaders{
+				ResponseHeaders: &pb.HeadersResponse{},
+			},
+			ModeOverride: st.callMode().override(),
+		}
+		// a trailers-only response, sent when a call fails before any
+		// message, carries its status in the headers
+		if hm := st.status(v.ResponseHeaders.Headers); hm != nil {
+			resp.GetResponseHeaders().Response = &pb.CommonResponse{HeaderMutation: hm}
+		}
+		resp.DynamicMetadata = st.dynamicMetadata()
+		return st.send(ctx, resp)
+
+	case *pb.ProcessingRequest_ResponseBody:
+		log.Printf("   ResponseBody: %d bytes", len(v.ResponseBody.Body))
+		log.Printf("   EndOfStream: %t", v.ResponseBody.EndOfStream)
+
+		return st.response.body(ctx, v.ResponseBody, responseBodyResponse)
+
+	case *pb.ProcessingRequest_ResponseTrailers:
+		log.Printf("pb.ProcessingRequest_ResponseTrailers %v \n", v)
+		if pass, err := st.response.endBody(ctx, responseBodyResponse); !pass || err != nil {
+			return err
+		}
+		resp := &pb.ProcessingResponse{
+			Response: &pb.ProcessingResponse_ResponseTrailers{
+				ResponseTrailers: &pb.TrailersResponse{
+					HeaderMutation: st.status(v.ResponseTrailers.Trailers),
+				},
+			},
+		}
+		resp.DynamicMetadata = st.dynamicMetadata()
+		return st.send(ctx, resp)
+
+	default:
+		log.Printf("Unknown Request type %v\n", v)
+		return st.send(ctx, &pb.ProcessingResponse{})
+	}
+}
+
+// body feeds one HttpBody chunk through the reassembler and rewrites every
+// message it completes.
+//
+// In BUFFERED and STREAMED mode exactly one response is sent per chunk,
+// carrying the messages completed by it; bytes of a message that is still
+// incomplete are held back until the chunk that finishes it.  In
+// FULL_DUPLEX_STREAMED mode each message is sent back on its own as soon as
+// it is complete, and the last one carries end_of_stream.
+//
+// In BUFFERED mode the chunk is the whole body, so a message it leaves
+// incomplete was cut off even if trailers are still to come.  Once the body
+// cannot be split into messages, the bytes from the bad frame on are
+// forwarded as they are if --onMalformed allows it.
+func (d *direction) body(ctx context.Context, body *pb.HttpBody, wrap func(*pb.BodyResponse) *pb.ProcessingResponse) error {
+	var frames []*frame.Frame
+	// rest is forwarded unchanged after the frames.
+	var rest []byte
+	if d.unframed {
+		rest = body.Body
+	} else {
+		var err error
+		frames, err = d.frames.Write(body.Body)
+		if err == nil && (body.EndOfStream || d.bodyMode() == v3.ProcessingMode_BUFFERED) {
+			err = d.frames.Close()
+		}
+		if err != nil {
+			if pass, err := d.malformed(ctx, err); !pass {
+				return err
+			}
+			rest = d.frames.Flush()
+			d.unframed = true
+		}
+	}
+
+	if bodySendMode == v3.ProcessingMode_FULL_DUPLEX_STREAMED {
+		for i, f := range frames {
+			nf, err := d.message(ctx, f)
+			if err != nil || nf == nil {
+				return err
+			}
+			b, err := frame.Marshal(nf)
+			if err != nil {
+				return err
+			}
+			if len(d.headers) > 0 {
+				log.Printf("   dropping header changes %v: headers cannot change in full_duplex_streamed mode", d.headers)
+				d.headers = nil
+			}
+			resp := wrap(streamedBodyResponse(b, body.EndOfStream && len(rest) == 0 && i == len(frames)-1))
+			resp.DynamicMetadata = d.st.dynamicMetadata()
+			if err := d.st.send(ctx, resp); err != nil {
+				return err
+			}
+		}
+		if len(rest) > 0 || (body.EndOfStream && len(frames) == 0) {
+			return d.st.send(ctx, wrap(streamedBodyResponse(rest, body.EndOfStream)))
+		}
+		return nil
+	}
+
+	var out bytes.Buffer
+	enc := frame.NewEncoder(&out)
+	for _, f := range frames {
+		nf, err := d.message(ctx, f)
+		if err != nil || nf == nil {
+			return err
+		}
+		err = enc.Encode(nf)
+		if err != nil {
+			log.Printf("Error NewEncoder.Encode: %v\n", err)
+			return err
+		}
+	}
+	out.Write(rest)
+	br := bodyResponse(body, out.Bytes())
+	hm := headerMutation(d.headers)
+	d.headers = nil
+	if hm != nil {
+		if br.Response == nil {
+			br.Response = &pb.CommonResponse{}
+		}
+		br.Response.HeaderMutation = hm
+		// have envoy pick the route again, as it may depend on the headers
+		br.Response.ClearRouteCache = d.dir == rules.Request
+	}
+	resp := wrap(br)
+	resp.DynamicMetadata = d.st.dynamicMetadata()
+	return d.st.send(ctx, resp)
+}
+
+// endBody is called when trailers arrive, ending the body.  Bytes still held
+// back belong to a message the trailers cut off.  If --onMalformed passes
+// them, they are sent on as one last body chunk in FULL_DUPLEX_STREAMED mode;
+// in STREAMED mode envoy takes no more body once trailers arrive, so they are
+// dropped.  It reports whether the trailers should be replied to.
+func (d *direction) endBody(ctx context.Context, wrap func(*pb.BodyResponse) *pb.ProcessingResponse) (bool, error) {
+	err := d.frames.Close()
+	if err == nil {
+		return true, nil
+	}
+	if pass, err := d.malformed(ctx, err); !pass {
+		return false, err
+	}
+	rest := d.frames.Flush()
+	d.unframed = true
+	if bodySendMode == v3.ProcessingMode_FULL_DUPLEX_STREAMED {
+		return true, d.st.send(ctx, wrap(streamedBodyResponse(rest, false)))
+	}
+	log.Printf("   dropping %d bytes held back for the cut off %s message", len(rest), d.dir)
+	return true, nil
+}
+
+// bodyMode is how envoy sends this direction's body.
+func (d *direction) bodyMode() v3.ProcessingMode_BodySendMode {
+	if bodySendMode == v3.ProcessingMode_FULL_DUPLEX_STREAMED {
+		return bodySendMode
+	}
+	m := d.st.callMode()
+	if d.dir == rules.Request {
+		return m.requestBody
+	}
+	return m.responseBody
+}
+
+// message returns the frame to forward in place of f.  It returns nil if the
+// call has been ended, by a deny rule or by --onMalformed, along with the
+// error to end the ext_proc stream with, if any.
+func (d *direction) message(ctx context.Context, f *frame.Frame) (*frame.Frame, error) {
+	nf, denied, err := d.rewriteFrame(ctx, f)
+	switch {
+	case denied != nil:
+		return nil, d.st.deny(ctx, denied)
+	case errors.Is(err, errMalformed):
+		if pass, err := d.malformed(ctx, err); !pass {
+			return nil, err
+		}
+		return f, nil
+	}
+	return nf, err
+}
+
+// errMalformed wraps the errors of messages that cannot be decoded.
+var errMalformed = errors.New("malformed message")
+
+// malformed applies --onMalformed to a message that cannot be decoded.  It
+// reports whether the message should be passed through unchanged; if not,
+// the call has been ended and err is what to end the ext_proc stream with.
+func (d *direction) malformed(ctx context.Context, cause error) (pass bool, err error) {
+	outcome := malformedPolicy
+	if outcome == "" {
+		outcome = malformedPassthrough
+	}
+	log.Printf("   %s: %v: %s", d.dir, cause, outcome)
+	metrics.MalformedMessages.WithLabelValues(d.dir.String(), outcome).Inc()
+	switch outcome {
+	case malformedReject:
+		return false, d.st.immediateResponse(ctx, status.Newf(codes.InvalidArgument, "malformed %s message", d.dir), "malformed message")
+	case malformedAbort:
+		return false, status.Errorf(codes.InvalidArgument, "malformed %s message: %v", d.dir, cause)
+	}
+	return true, nil
+}
+
+// rewriteFrame decodes one message as the method's input or output type,
+// applies the stream's rules and any extproc handler, and returns the frame
+// to forward in its place, or the denial if a rule or handler denied the call.
+func (d *direction) rewriteFrame(ctx context.Context, f *frame.Frame) (*frame.Frame, *rules.Denial, error) {
+	md := d.st.methodDescriptor()
+	if md == nil {
+		return f, nil, nil
+	}
+	payload, err := messagePayload(f, d.encoding)
+	if errors.Is(err, compression.ErrUnsupported) {
+		// the message may be fine; the filter just can't read it
+		log.Printf("   skipping message: %v", err)
+		return f, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	msg := dynamicpb.NewMessage(d.messageType(md))
+	err = proto.Unmarshal(payload, msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	sensitive := d.st.config.Sensitive
+	log.Printf("   %s %s: %s", d.dir, msg.Descriptor().FullName(), sensitive.Format(msg))
+
+	res := d.st.config.Rules.Apply(md, d.dir, msg, d.st.ruleVars())
+	// the handler sees the request headers as the rules left them
+	if len(res.Headers) > 0 {
+		d.headers = append(d.headers, res.Headers...)
+		if d.dir == rules.Request {
+			d.st.changeHeaders(res.Headers)
+		}
+	}
+	if res.Denied == nil {
+		res.Denied = d.handle(ctx, d.st.ruleVars(), msg, res)
+	}
+	// marked fields are only masked on the way back to the client; the
+	// upstream still needs them
+	if d.dir == rules.Response && sensitive != nil {
+		sum := redact.Summary{}
+		sensitive.Redact(msg, sum)
+		for path, m := range sum {
+			res.Redacted.Add(path, m.Strategy, m.Count)
+			res.Changed = true
+		}
+	}
+	d.st.addResult(res)
+	if res.Denied != nil {
+		return nil, res.Denied, nil
+	}
+	if d.dir == rules.Request {
+		d.st.setRequest(msg)
+	}
+	if !res.Changed {
+		return f, nil, nil
+	}
+
+	bb, err := proto.Marshal(msg)
+	if err != nil {
+		log.Printf("Error Marshalling message: %v\n", err)
+		return nil, nil, err
+	}
+
+	nf, err := newFrame(bb, f.Compressed, d.encoding)
+	if err != nil {
+		log.Printf("Error compressing message: %v\n", err)
+		return nil, nil, err
+	}
+	return nf, nil, nil
+}
+
+// handle runs the extproc handler registered for the call and direction, if
+// any, on msg.  It returns the denial if the handler denied the call.  A
+// handler that fails leaves msg as it was.
+func (d *direction) handle(ctx context.Context, vars *rules.Vars, msg protoreflect.Message, res *rules.Result) *rules.Denial {
+	method := vars.Headers[":path"]
+	c := &extproc.Context{
+		Context:    ctx,
+		Method:     method,
+		Headers:    vars.Headers,
+		Attributes: vars.Attributes,
+		Metadata:   vars.Metadata,
+		Values:     vars.Values,
+		State:      &d.st.state,
+	}
+	v, err := extproc.Handle(c, d.dir, msg)
+	if err != nil {
+		log.Printf("   %s handler for %s: %v", d.dir, method, err)
+		return nil
+	}
+	if s := v.Denied(); s != nil {
+		return &rules.Denial{Rule: "handler " + method, Status: s}
+	}
+	if v.Mutated() {
+		res.Changed = true
+	}
+	return nil
+}
+
+// headerMutation returns the HeaderMutation making changes, or nil if there
+// are none.  Only the last change to each header is kept.
+func headerMutation(changes []rules.HeaderChange) *pb.HeaderMutation {
+	if len(changes) == 0 {
+		return nil
+	}
+	var names []string
+	last := map[string]rules.HeaderChange{}
+	for _, c := range changes {
+		if _, ok := last[c.Name]; !ok {
+			names = append(names, c.Name)
+		}
+		last[c.Name] = c
+	}
+
+	hm := &pb.HeaderMutation{}
+	for _, n := range names {
+		if c := last[n]; c.Remove {
+			hm.RemoveHeaders = append(hm.RemoveHeaders, n)
+		} else {
+			hm.SetHeaders = append(hm.SetHeaders, setHeader(n, c.Value))
+		}
+	}
+	return hm
+}
+
+// bodyResponse returns the BodyResponse for a body chunk, replacing the
+// chunk only if the bytes to forward differ from what envoy sent.
+func bodyResponse(body *pb.HttpBody, out []byte) *pb.BodyResponse {
+	if bytes.Equal(body.Body, out) {
+		return &pb.BodyResponse{}
+	}
+	return &pb.BodyResponse{
+		Response: &pb.CommonResponse{
+			BodyMutation: &pb.BodyMutation{
+				Mutation: &pb.BodyMutation_Body{
+					Body: out,
+				},
+			},
+		},
+	}
+}
+
+func requestBodyResponse(br *pb.BodyResponse) *pb.ProcessingResponse {
+	return &pb.ProcessingResponse{
+		Response: &pb.ProcessingResponse_RequestBody{
+			RequestBody: br,
+		},
+	}
+}
+
+func responseBodyResponse(br *pb.BodyResponse) *pb.ProcessingResponse {
+	return &pb.ProcessingResponse{
+		Response: &pb.ProcessingResponse_ResponseBody{
+			ResponseBody: br,
+		},
+	}
+}
+
+func streamedBodyResponse(b []byte, endOfStream bool) *pb.BodyResponse {
+	return &pb.BodyResponse{
+		Response: &pb.CommonResponse{
+			BodyMutation: &pb.BodyMutation{
+				Mutation: &pb.BodyMutation_StreamedResponse{
+					StreamedResponse: &pb.StreamedBodyResponse{
+						Body:        b,
+						EndOfStream: endOfStream,
+					},
+				},
+			},
+		},
+	}
+}
+
+// modeOverride returns m, or nil in FULL_DUPLEX_STREAMED mode where envoy
+// does not allow the processing mode to change mid-stream.
+func modeOverride(m *v3.ProcessingMode) *v3.ProcessingMode {
+	if bodySendMode == v3.ProcessingMode_FULL_DUPLEX_STREAMED {
+		return nil
+	}
+	return m
+}
+
+func setHeader(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, RawValue: []byte(value)},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// headerValue returns the value of h; newer envoys only populate raw_value.
+func headerValue(h *corev3.HeaderValue) string {
+	if h.Value != "" {
+		return h.Value
+	}
+	return string(h.RawValue)
+}
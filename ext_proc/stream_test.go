@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/salrashid123/envoy_grpc_decode/ext_proc/config"
+)
+
+// reply hands req to st and returns what st sends back to envoy.
+func reply(t *testing.T, st *stream, req *pb.ProcessingRequest) *pb.ProcessingResponse {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- st.handle(context.Background(), req) }()
+	select {
+	case resp := <-st.out:
+		return resp
+	case err := <-errc:
+		t.Fatalf("no reply to %v: %v", req, err)
+	}
+	return nil
+}
+
+func headers(kv ...string) *corev3.HeaderMap {
+	h := &corev3.HeaderMap{}
+	for i := 0; i < len(kv); i += 2 {
+		h.Headers = append(h.Headers, &corev3.HeaderValue{Key: kv[i], Value: kv[i+1]})
+	}
+	return h
+}
+
+// withRules makes the echo descriptors and rules y the config for the test.
+func withRules(t *testing.T, y string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(p, []byte(y), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := config.NewWatcher(config.Sources{
+		DescriptorSets: []string{"../grpc_server/echo/echo.proto.pb"},
+		Rules:          []string{p},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, oldMode := configWatcher, bodySendMode
+	configWatcher, bodySendMode = w, v3.ProcessingMode_BUFFERED
+	t.Cleanup(func() { configWatcher, bodySendMode = old, oldMode })
+}
+
+func TestResponseHeadersModeOverride(t *testing.T) {
+	withRules(t, `
+rules:
+- method: /echo.EchoServer/SayHelloUnary
+  direction: status
+  actions:
+  - set: message
+    value: rewritten
+`)
+	st := newStream(configWatcher.Current(), nil)
+	reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+		Headers: headers(":method", "POST", ":path", "/echo.EchoServer/SayHelloUnary", "content-type", "application/grpc"),
+	}}})
+	resp := reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseHeaders{ResponseHeaders: &pb.HttpHeaders{
+		Headers: headers(":status", "200", "content-type", "application/grpc"),
+	}}})
+
+	if resp.GetResponseHeaders() == nil {
+		t.Fatalf("got %v, want a response headers reply", resp)
+	}
+	m := resp.GetModeOverride()
+	if m.GetResponseTrailerMode() != v3.ProcessingMode_SEND {
+		t.Errorf("response_trailer_mode = %v, want SEND", m.GetResponseTrailerMode())
+	}
+	if m.GetResponseHeaderMode() != v3.ProcessingMode_SEND {
+		t.Errorf("response_header_mode = %v, want SEND", m.GetResponseHeaderMode())
+	}
+	if m.GetResponseBodyMode() != v3.ProcessingMode_BUFFERED {
+		t.Errorf("response_body_mode = %v, want BUFFERED", m.GetResponseBodyMode())
+	}
+}
+
+func TestRequestHeadersModeOverride(t *testing.T) {
+	withRules(t, `
+processing:
+- method: /echo.EchoServer/SayHelloServerStream
+  request_body: buffered
+  response_body: streamed
+  response_trailers: send
+`)
+	tests := []struct {
+		path, contentType string
+		want              *v3.ProcessingMode
+	}{
+		{"/echo.EchoServer/SayHelloServerStream", "application/grpc", &v3.ProcessingMode{
+			RequestHeaderMode:   v3.ProcessingMode_SEND,
+			RequestBodyMode:     v3.ProcessingMode_BUFFERED,
+			RequestTrailerMode:  v3.ProcessingMode_SEND,
+			ResponseHeaderMode:  v3.ProcessingMode_SEND,
+			ResponseBodyMode:    v3.ProcessingMode_STREAMED,
+			ResponseTrailerMode: v3.ProcessingMode_SEND,
+		}},
+		// no rule applies to the method
+		{"/echo.EchoServer/SayHelloUnary", "application/grpc", &v3.ProcessingMode{
+			RequestHeaderMode:   v3.ProcessingMode_SEND,
+			RequestBodyMode:     v3.ProcessingMode_NONE,
+			RequestTrailerMode:  v3.ProcessingMode_SKIP,
+			ResponseHeaderMode:  v3.ProcessingMode_SKIP,
+			ResponseBodyMode:    v3.ProcessingMode_NONE,
+			ResponseTrailerMode: v3.ProcessingMode_SKIP,
+		}},
+	}
+	for _, tc := range tests {
+		st := newStream(configWatcher.Current(), nil)
+		resp := reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+			Headers: headers(":method", "POST", ":path", tc.path, "content-type", tc.contentType),
+		}}})
+		if got := resp.GetModeOverride(); !proto.Equal(got, tc.want) {
+			t.Errorf("%s: mode_override = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+// startCall sends the request and response headers of a call to
+// SayHelloUnary, which the rules must decode.
+func startCall(t *testing.T, st *stream) {
+	t.Helper()
+	reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+		Headers: headers(":method", "POST", ":path", "/echo.EchoServer/SayHelloUnary", "content-type", "application/grpc"),
+	}}})
+	reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseHeaders{ResponseHeaders: &pb.HttpHeaders{
+		Headers: headers(":status", "200", "content-type", "application/grpc"),
+	}}})
+}
+
+const rewriteReplies = `
+rules:
+- method: /echo.EchoServer/SayHelloUnary
+  direction: response
+  actions:
+  - set: message
+    value: rewritten
+`
+
+// cutOff is the start of a message the response trailers cut off.
+var cutOff = []byte{0, 0, 0, 0, 10, 0x0a, 0x08}
+
+var responseTrailers = &pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseTrailers{ResponseTrailers: &pb.HttpTrailers{
+	Trailers: headers("grpc-status", "0"),
+}}}
+
+func TestBufferedBodyCutOffBeforeTrailers(t *testing.T) {
+	withRules(t, rewriteReplies)
+	st := newStream(configWatcher.Current(), nil)
+	startCall(t, st)
+
+	resp := reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseBody{ResponseBody: &pb.HttpBody{
+		Body: cutOff,
+	}}})
+	if resp.GetResponseBody() == nil {
+		t.Fatalf("got %v, want a response body reply", resp)
+	}
+	if m := resp.GetResponseBody().GetResponse().GetBodyMutation(); m != nil {
+		t.Errorf("body_mutation = %v, want the body passed through", m)
+	}
+	if resp := reply(t, st, responseTrailers); resp.GetResponseTrailers() == nil {
+		t.Errorf("got %v, want a response trailers reply", resp)
+	}
+}
+
+func TestFullDuplexBodyCutOffByTrailers(t *testing.T) {
+	withRules(t, rewriteReplies)
+	bodySendMode = v3.ProcessingMode_FULL_DUPLEX_STREAMED
+	st := newStream(configWatcher.Current(), nil)
+	startCall(t, st)
+
+	// nothing is complete, so nothing is sent back yet
+	if err := st.handle(context.Background(), &pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseBody{ResponseBody: &pb.HttpBody{
+		Body: cutOff,
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := reply(t, st, responseTrailers)
+	sr := resp.GetResponseBody().GetResponse().GetBodyMutation().GetStreamedResponse()
+	if sr == nil || !bytes.Equal(sr.Body, cutOff) {
+		t.Fatalf("got %v, want the held bytes sent on before the trailers", resp)
+	}
+	if resp := <-st.out; resp.GetResponseTrailers() == nil {
+		t.Errorf("got %v, want a response trailers reply", resp)
+	}
+}
+
+func TestTrailerModes(t *testing.T) {
+	withRules(t, `
+rules:
+- method: /echo.EchoServer/*
+  direction: response
+  actions:
+  - set: message
+    value: rewritten
+processing:
+- method: /echo.EchoServer/SayHelloServerStream
+  request_body: none
+  response_trailers: skip
+`)
+	tests := []struct {
+		path                              string
+		requestTrailers, responseTrailers v3.ProcessingMode_HeaderSendMode
+	}{
+		{"/echo.EchoServer/SayHelloUnary", v3.ProcessingMode_SEND, v3.ProcessingMode_SEND},
+		{"/echo.EchoServer/SayHelloServerStream", v3.ProcessingMode_SKIP, v3.ProcessingMode_SKIP},
+	}
+	for _, tc := range tests {
+		st := newStream(configWatcher.Current(), nil)
+		resp := reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+			Headers: headers(":method", "POST", ":path", tc.path, "content-type", "application/grpc"),
+		}}})
+		m := resp.GetModeOverride()
+		if m.GetRequestTrailerMode() != tc.requestTrailers {
+			t.Errorf("%s: request_trailer_mode = %v, want %v", tc.path, m.GetRequestTrailerMode(), tc.requestTrailers)
+		}
+		if m.GetResponseTrailerMode() != tc.responseTrailers {
+			t.Errorf("%s: response_trailer_mode = %v, want %v", tc.path, m.GetResponseTrailerMode(), tc.responseTrailers)
+		}
+	}
+}
+
+func TestModeOverrideOnlyOnHeaderReplies(t *testing.T) {
+	withRules(t, rewriteReplies)
+	st := newStream(configWatcher.Current(), nil)
+	msg := []byte{0, 0, 0, 0, 0}
+	tests := []struct {
+		req      *pb.ProcessingRequest
+		override bool
+	}{
+		{&pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+			Headers: headers(":method", "POST", ":path", "/echo.EchoServer/SayHelloUnary", "content-type", "application/grpc"),
+		}}}, true},
+		{&pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestBody{RequestBody: &pb.HttpBody{Body: msg}}}, false},
+		{&pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestTrailers{RequestTrailers: &pb.HttpTrailers{}}}, false},
+		{&pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseHeaders{ResponseHeaders: &pb.HttpHeaders{
+			Headers: headers(":status", "200", "content-type", "application/grpc"),
+		}}}, true},
+		{&pb.ProcessingRequest{Request: &pb.ProcessingRequest_ResponseBody{ResponseBody: &pb.HttpBody{Body: msg}}}, false},
+		{responseTrailers, false},
+	}
+	for _, tc := range tests {
+		resp := reply(t, st, tc.req)
+		if got := resp.GetModeOverride() != nil; got != tc.override {
+			t.Errorf("reply %T has mode_override %t, want %t", resp.Response, got, tc.override)
+		}
+	}
+}
+
+func TestModeOverrideFullDuplex(t *testing.T) {
+	withRules(t, rewriteReplies)
+	bodySendMode = v3.ProcessingMode_FULL_DUPLEX_STREAMED
+	if m := modeOverride(&v3.ProcessingMode{}); m != nil {
+		t.Errorf("modeOverride = %v, want nil", m)
+	}
+	st := newStream(configWatcher.Current(), nil)
+	resp := reply(t, st, &pb.ProcessingRequest{Request: &pb.ProcessingRequest_RequestHeaders{RequestHeaders: &pb.HttpHeaders{
+		Headers: headers(":method", "POST", ":path", "/echo.EchoServer/SayHelloUnary", "content-type", "application/grpc"),
+	}}})
+	if m := resp.GetModeOverride(); m != nil {
+		t.Errorf("request headers reply has mode_override %v, want none", m)
+	}
+}
@@ -0,0 +1,70 @@
+// Code generated by protoc-gen-extproc. DO NOT EDIT.
+// source: echo/echo.proto
+
+package echo
+
+import (
+	extproc "github.com/salrashid123/envoy_grpc_decode/ext_proc/extproc"
+)
+
+// EchoServerHooks is called with each message of the calls to echo.EchoServer,
+// once the filter has decoded it.
+type EchoServerHooks interface {
+	OnSayHelloUnaryRequest(*extproc.Context, *EchoRequest) extproc.Verdict
+	OnSayHelloUnaryResponse(*extproc.Context, *EchoReply) extproc.Verdict
+	OnSayHelloServerStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict
+	OnSayHelloServerStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict
+	OnSayHelloClientStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict
+	OnSayHelloClientStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict
+	OnSayHelloBiDiStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict
+	OnSayHelloBiDiStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict
+}
+
+// UnimplementedEchoServerHooks passes every message.  Embed it to implement only
+// some of the hooks.
+type UnimplementedEchoServerHooks struct{}
+
+func (UnimplementedEchoServerHooks) OnSayHelloUnaryRequest(*extproc.Context, *EchoRequest) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloUnaryResponse(*extproc.Context, *EchoReply) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloServerStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloServerStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloClientStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloClientStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloBiDiStreamRequest(*extproc.Context, *EchoRequest) extproc.Verdict {
+	return extproc.Pass()
+}
+
+func (UnimplementedEchoServerHooks) OnSayHelloBiDiStreamResponse(*extproc.Context, *EchoReply) extproc.Verdict {
+	return extproc.Pass()
+}
+
+// RegisterEchoServerHooks registers h for every method of echo.EchoServer.
+// Every method is decoded, including those h passes.
+func RegisterEchoServerHooks(h EchoServerHooks) {
+	extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloUnary", h.OnSayHelloUnaryRequest)
+	extproc.RegisterResponseHandler("/echo.EchoServer/SayHelloUnary", h.OnSayHelloUnaryResponse)
+	extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloServerStream", h.OnSayHelloServerStreamRequest)
+	extproc.RegisterResponseHandler("/echo.EchoServer/SayHelloServerStream", h.OnSayHelloServerStreamResponse)
+	extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloClientStream", h.OnSayHelloClientStreamRequest)
+	extproc.RegisterResponseHandler("/echo.EchoServer/SayHelloClientStream", h.OnSayHelloClientStreamResponse)
+	extproc.RegisterRequestHandler("/echo.EchoServer/SayHelloBiDiStream", h.OnSayHelloBiDiStreamRequest)
+	extproc.RegisterResponseHandler("/echo.EchoServer/SayHelloBiDiStream", h.OnSayHelloBiDiStreamResponse)
+}
@@ -24,6 +24,8 @@ const _ = grpc.SupportPackageIsVersion7
 type EchoServerClient interface {
 	SayHelloUnary(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoReply, error)
 	SayHelloServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (EchoServer_SayHelloServerStreamClient, error)
+	SayHelloClientStream(ctx context.Context, opts ...grpc.CallOption) (EchoServer_SayHelloClientStreamClient, error)
+	SayHelloBiDiStream(ctx context.Context, opts ...grpc.CallOption) (EchoServer_SayHelloBiDiStreamClient, error)
 }
 
 type echoServerClient struct {
@@ -75,12 +77,79 @@ func (x *echoServerSayHelloServerStreamClient) Recv() (*EchoReply, error) {
 	return m, nil
 }
 
+func (c *echoServerClient) SayHelloClientStream(ctx context.Context, opts ...grpc.CallOption) (EchoServer_SayHelloClientStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoServer_ServiceDesc.Streams[1], "/echo.EchoServer/SayHelloClientStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServerSayHelloClientStreamClient{stream}
+	return x, nil
+}
+
+type EchoServer_SayHelloClientStreamClient interface {
+	Send(*EchoRequest) error
+	CloseAndRecv() (*EchoReply, error)
+	grpc.ClientStream
+}
+
+type echoServerSayHelloClientStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServerSayHelloClientStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoServerSayHelloClientStreamClient) CloseAndRecv() (*EchoReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(EchoReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoServerClient) SayHelloBiDiStream(ctx context.Context, opts ...grpc.CallOption) (EchoServer_SayHelloBiDiStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoServer_ServiceDesc.Streams[2], "/echo.EchoServer/SayHelloBiDiStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServerSayHelloBiDiStreamClient{stream}
+	return x, nil
+}
+
+type EchoServer_SayHelloBiDiStreamClient interface {
+	Send(*EchoRequest) error
+	Recv() (*EchoReply, error)
+	grpc.ClientStream
+}
+
+type echoServerSayHelloBiDiStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServerSayHelloBiDiStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoServerSayHelloBiDiStreamClient) Recv() (*EchoReply, error) {
+	m := new(EchoReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // EchoServerServer is the server API for EchoServer service.
 // All implementations must embed UnimplementedEchoServerServer
 // for forward compatibility
 type EchoServerServer interface {
 	SayHelloUnary(context.Context, *EchoRequest) (*EchoReply, error)
 	SayHelloServerStream(*EchoRequest, EchoServer_SayHelloServerStreamServer) error
+	SayHelloClientStream(EchoServer_SayHelloClientStreamServer) error
+	SayHelloBiDiStream(EchoServer_SayHelloBiDiStreamServer) error
 	mustEmbedUnimplementedEchoServerServer()
 }
 
@@ -94,6 +163,12 @@ func (UnimplementedEchoServerServer) SayHelloUnary(context.Context, *EchoRequest
 func (UnimplementedEchoServerServer) SayHelloServerStream(*EchoRequest, EchoServer_SayHelloServerStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method SayHelloServerStream not implemented")
 }
+func (UnimplementedEchoServerServer) SayHelloClientStream(EchoServer_SayHelloClientStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method SayHelloClientStream not implemented")
+}
+func (UnimplementedEchoServerServer) SayHelloBiDiStream(EchoServer_SayHelloBiDiStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method SayHelloBiDiStream not implemented")
+}
 func (UnimplementedEchoServerServer) mustEmbedUnimplementedEchoServerServer() {}
 
 // UnsafeEchoServerServer may be embedded to opt out of forward compatibility for this service.
@@ -146,6 +221,58 @@ func (x *echoServerSayHelloServerStreamServer) Send(m *EchoReply) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _EchoServer_SayHelloClientStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServerServer).SayHelloClientStream(&echoServerSayHelloClientStreamServer{stream})
+}
+
+type EchoServer_SayHelloClientStreamServer interface {
+	SendAndClose(*EchoReply) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoServerSayHelloClientStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServerSayHelloClientStreamServer) SendAndClose(m *EchoReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoServerSayHelloClientStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoServer_SayHelloBiDiStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServerServer).SayHelloBiDiStream(&echoServerSayHelloBiDiStreamServer{stream})
+}
+
+type EchoServer_SayHelloBiDiStreamServer interface {
+	Send(*EchoReply) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoServerSayHelloBiDiStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServerSayHelloBiDiStreamServer) Send(m *EchoReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoServerSayHelloBiDiStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // EchoServer_ServiceDesc is the grpc.ServiceDesc for EchoServer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -164,6 +291,17 @@ var EchoServer_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _EchoServer_SayHelloServerStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "SayHelloClientStream",
+			Handler:       _EchoServer_SayHelloClientStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SayHelloBiDiStream",
+			Handler:       _EchoServer_SayHelloBiDiStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "echo/echo.proto",
 }
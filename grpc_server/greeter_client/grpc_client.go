@@ -14,12 +14,14 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 var (
 	address    = flag.String("host", "localhost:8081", "host:port of gRPC server")
 	tlsCACert  = flag.String("cacert", "../certs/root-ca.crt", "tls CA Certificate")
 	serverName = flag.String("servername", "grpc.domain.com", "CACert for server")
+	compressor = flag.String("compressor", "", "grpc-encoding to send messages with (eg gzip)")
 )
 
 const (
@@ -46,7 +48,11 @@ func main() {
 	tlsCfg.ServerName = *serverName
 
 	ce := credentials.NewTLS(&tlsCfg)
-	conn, err = grpc.Dial(*address, grpc.WithTransportCredentials(ce))
+	dopts := []grpc.DialOption{grpc.WithTransportCredentials(ce)}
+	if *compressor != "" {
+		dopts = append(dopts, grpc.WithDefaultCallOptions(grpc.UseCompressor(*compressor)))
+	}
+	conn, err = grpc.Dial(*address, dopts...)
 
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -85,4 +91,45 @@ func main() {
 		log.Printf("%s", m.Message)
 	}
 
+	// ***** CLIENT Streaming
+	cstream, err := c.SayHelloClientStream(ctx)
+	if err != nil {
+		log.Fatalf("SayHelloClientStream(_) = _, %v", err)
+	}
+	for _, name := range []string{"alice", "carol", "alice"} {
+		if err := cstream.Send(&echo.EchoRequest{Name: name}); err != nil {
+			log.Fatalf("SayHelloClientStream.Send(_) = %v", err)
+		}
+	}
+	r, err = cstream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("SayHelloClientStream.CloseAndRecv() = _, %v", err)
+	}
+	log.Printf("%s", r.Message)
+
+	// ***** BIDI Streaming
+	bstream, err := c.SayHelloBiDiStream(ctx)
+	if err != nil {
+		log.Fatalf("SayHelloBiDiStream(_) = _, %v", err)
+	}
+	for _, name := range []string{"alice", "carol"} {
+		if err := bstream.Send(&echo.EchoRequest{Name: name}); err != nil {
+			log.Fatalf("SayHelloBiDiStream.Send(_) = %v", err)
+		}
+	}
+	if err := bstream.CloseSend(); err != nil {
+		log.Fatalf("SayHelloBiDiStream.CloseSend() = %v", err)
+	}
+	for {
+		m, err := bstream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("SayHelloBiDiStream(_) = _, %v", err)
+		}
+
+		log.Printf("%s", m.Message)
+	}
+
 }
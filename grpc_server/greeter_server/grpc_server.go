@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
 
 	"github.com/salrashid123/envoy_grpc_decode/echo"
 
@@ -13,12 +15,18 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 /*
 protoc --go_out=.    --go_opt=paths=source_relative  --descriptor_set_out=echo/echo.proto.pb   --go-grpc_out=. --go-grpc_opt=paths=source_relative     echo/echo.proto
+
+typed ext_proc hooks (see ext_proc/cmd/protoc-gen-extproc):
+protoc --go_out=.    --go_opt=paths=source_relative  --extproc_out=. --extproc_opt=paths=source_relative     echo/echo.proto
 */
 var (
 	grpcport = flag.String("grpcport", ":50051", "grpcport")
@@ -46,7 +54,7 @@ func (s *server) SayHelloUnary(ctx context.Context, in *echo.EchoRequest) (*echo
 
 	headers, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return nil, grpc.Errorf(codes.PermissionDenied, fmt.Sprintf("Could not read inbound metadata"))
+		return nil, status.Errorf(codes.PermissionDenied, "Could not read inbound metadata")
 	}
 	log.Printf("Metadata Headers %v", headers)
 
@@ -63,6 +71,41 @@ func (s *server) SayHelloServerStream(in *echo.EchoRequest, stream echo.EchoServ
 	return nil
 }
 
+func (s *server) SayHelloClientStream(stream echo.EchoServer_SayHelloClientStreamServer) error {
+
+	log.Println("Got client stream:  -->  ")
+	var names []string
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&echo.EchoReply{Message: "hi " + strings.Join(names, ", ")})
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Got rpc: --> %s", in.Name)
+		names = append(names, in.Name)
+	}
+}
+
+func (s *server) SayHelloBiDiStream(stream echo.EchoServer_SayHelloBiDiStreamServer) error {
+
+	log.Println("Got bidi stream:  -->  ")
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Got rpc: --> %s", in.Name)
+		if err := stream.Send(&echo.EchoReply{Message: "hi " + in.Name}); err != nil {
+			return err
+		}
+	}
+}
+
 func main() {
 	flag.Parse()
 	if *grpcport == "" {
@@ -88,6 +131,8 @@ func main() {
 
 	s := grpc.NewServer(sopts...)
 	echo.RegisterEchoServerServer(s, &server{})
+	// lets the ext_proc filter fetch message descriptors with --reflectionAddress
+	reflection.Register(s)
 
 	log.Printf("Starting server...")
 	if err := s.Serve(lis); err != nil {